@@ -1,32 +1,69 @@
 package cmd
 
 import (
+	"fmt"
 	"log/slog"
 	"path/filepath"
+	"slices"
+	"time"
 	"velcro/internal/build"
 	"velcro/internal/siteconfig"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	buildOverrides  siteconfig.Overrides
+	buildConfigPath string
+	buildOutputDir  string
+	buildFuture     bool
+	buildNow        string
+)
+
 var buildCmd = &cobra.Command{
-	Use:   "build",
+	Use:   "build [path]",
 	Short: "Builds your Velcro blog",
-	Long:  `Builds your Velcro blog into a static site.`,
+	Long: `Builds your Velcro blog into a static site.
+
+The site config is looked up in path (the current directory by default) and
+then in each of its parents. Paths in the config are relative to the config file.
+
+Drafts, posts dated in the future and posts past their velcro:expires date are
+left out, and removed from the output if an earlier build wrote them.
+Use --future to include scheduled posts and --now to build as of another date.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) != 1 {
-			slog.Error("Please provide a path to your site config")
+		siteConfigPath, err := findConfigPath(buildConfigPath, args)
+		if err != nil {
+			slog.Error("Failed to find site config", "error", err)
 			return
 		}
-		rootDir := args[0]
+
+		overrides := buildOverrides
+		if buildOutputDir != "" {
+			// --out is relative to where velcro is run, not to the config
+			outputDir, err := filepath.Abs(buildOutputDir)
+			if err != nil {
+				slog.Error("Invalid output directory", "error", err)
+				return
+			}
+			overrides.Values = append(slices.Clone(overrides.Values), "output_dir="+outputDir)
+		}
 
 		opts := &build.BuildOptions{
-			RootDir: rootDir,
+			RootDir: filepath.Dir(siteConfigPath),
+			Future:  buildFuture,
 		}
 
-		siteConfigPath := filepath.Join(rootDir, "site.config.toml")
+		if buildNow != "" {
+			opts.Now, err = parseBuildTime(buildNow)
+			if err != nil {
+				slog.Error("Invalid --now", "error", err)
+				return
+			}
+		}
 
-		config, err := siteconfig.LoadSiteConfig(siteConfigPath)
+		config, err := siteconfig.LoadSiteConfig(siteConfigPath, overrides)
 		if err != nil {
 			slog.Error("Failed to load site config", "error", err)
 			return
@@ -43,6 +80,24 @@ var buildCmd = &cobra.Command{
 	},
 }
 
+// parseBuildTime reads a --now value, either a date such as 2026-01-31 or an
+// RFC 3339 time.
+func parseBuildTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a YYYY-MM-DD date or an RFC 3339 time", value)
+	}
+	return t, nil
+}
+
 func init() {
+	addOverrideFlags(buildCmd.Flags(), &buildOverrides)
+	buildCmd.Flags().StringVarP(&buildConfigPath, "config", "c", "", "path to the site config (default: search upwards for "+siteconfig.FileName+")")
+	buildCmd.Flags().StringVarP(&buildOutputDir, "out", "o", "", "write the site to this directory instead of output_dir")
+	buildCmd.Flags().BoolVar(&buildFuture, "future", false, "include posts dated in the future")
+	buildCmd.Flags().StringVar(&buildNow, "now", "", "build as if it were this date (YYYY-MM-DD or RFC 3339)")
 	rootCmd.AddCommand(buildCmd)
 }
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"velcro/internal/siteconfig"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	configOverrides siteconfig.Overrides
+	configPath      string
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect your site config",
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:   "check [path]",
+	Short: "Check the site config for mistakes",
+	Long: `Loads the site config and reports unknown keys, missing files and
+directories and invalid values.`,
+	Args: cobra.MaximumNArgs(1),
+	// Problems are logged as they are found, the returned error only sets the exit code
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, err := loadConfigArg(args)
+		var validationErr *siteconfig.ValidationError
+		if errors.As(err, &validationErr) {
+			for _, problem := range validationErr.Problems {
+				slog.Error("Site config problem", "problem", problem)
+			}
+			return err
+		}
+		if err != nil {
+			slog.Error("Failed to load site config", "error", err)
+			return err
+		}
+
+		slog.Info("✅ Site config is valid")
+		return nil
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show [path]",
+	Short: "Print the effective site config",
+	Long:  `Prints the site config with every default and override filled in.`,
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadConfigArg(args)
+		if err != nil {
+			slog.Error("Failed to load site config", "error", err)
+			return
+		}
+
+		err = toml.NewEncoder(cmd.OutOrStdout()).Encode(config)
+		if err != nil {
+			slog.Error("Failed to print site config", "error", err)
+			return
+		}
+	},
+}
+
+// loadConfigArg loads the site config found from --config or the optional path argument.
+func loadConfigArg(args []string) (*siteconfig.SiteConfig, error) {
+	siteConfigPath, err := findConfigPath(configPath, args)
+	if err != nil {
+		return nil, err
+	}
+	return siteconfig.LoadSiteConfig(siteConfigPath, configOverrides)
+}
+
+// findConfigPath returns the config given with --config, or else searches upwards
+// from the path argument (the current directory by default). The path argument may
+// also name a config file directly.
+func findConfigPath(configFlag string, args []string) (string, error) {
+	if configFlag != "" {
+		return configFlag, nil
+	}
+
+	start := "."
+	if len(args) == 1 {
+		start = args[0]
+	}
+
+	info, err := os.Stat(start)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return start, nil
+	}
+
+	return siteconfig.FindSiteConfig(start)
+}
+
+// addOverrideFlags adds the --env and --set flags that override site config keys.
+func addOverrideFlags(flags *pflag.FlagSet, overrides *siteconfig.Overrides) {
+	flags.StringVarP(&overrides.Env, "env", "e", "", "apply the [env.<name>] table from the site config (default $"+siteconfig.EnvVariable+")")
+	flags.StringArrayVar(&overrides.Values, "set", nil, "override a config key, such as --set images.quality=90")
+}
+
+func init() {
+	addOverrideFlags(configCmd.PersistentFlags(), &configOverrides)
+	configCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "path to the site config (default: search upwards for "+siteconfig.FileName+")")
+	configCmd.AddCommand(configCheckCmd)
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -3,30 +3,64 @@ package cmd
 import (
 	"embed"
 	"fmt"
-	"io"
+	"html"
 	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"velcro/internal/upgrade"
 
 	"github.com/spf13/cobra"
 )
 
-//go:embed init_template
+//go:embed all:init_template
 var templateFS embed.FS
 
+var (
+	initTemplate string
+	initTitle    string
+	initAuthor   string
+	initHere     bool
+)
+
+// templateTextExtensions are the template files placeholders are substituted in.
+var templateTextExtensions = []string{".html", ".htm", ".css", ".js", ".toml", ".md", ".txt", ".json", ".svg", ".xml"}
+
 var initCmd = &cobra.Command{
-	Use:   "init",
+	Use:   "init [name]",
 	Short: "Initialize a new Velcro blog",
-	Long:  `Initializes a new Velcro blog with a default template.`,
+	Long: `Initializes a new Velcro blog from a starter template.
+
+Built-in templates: ` + strings.Join(builtinTemplates(), ", ") + `
+--template also accepts a path to any local directory to use as the template.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) != 1 {
-			slog.Error("Please provide a name for your blog")
-			return
+		var blogName string
+		var destDir string
+
+		if initHere {
+			cwd, err := os.Getwd()
+			if err != nil {
+				slog.Error("Failed to get the current directory", "error", err)
+				return
+			}
+			blogName = filepath.Base(cwd)
+			if len(args) == 1 {
+				blogName = args[0]
+			}
+			destDir = "."
+		} else {
+			if len(args) != 1 {
+				slog.Error("Please provide a name for your blog")
+				return
+			}
+			blogName = args[0]
+			destDir = filepath.Join(".", blogName)
 		}
-		blogName := args[0]
 
 		slog.Debug("Validating blog name", "blogName", blogName)
 		match, _ := regexp.MatchString("^[a-zA-Z0-9-_]+$", blogName)
@@ -35,85 +69,242 @@ var initCmd = &cobra.Command{
 			return
 		}
 
-		slog.Info("⚙️ Initializing your Velcro blog...")
-
-		slog.Debug("Checking if blog directory exists", "blogName", blogName)
-		if _, err := os.Stat(fmt.Sprintf("./%s", blogName)); err == nil {
-			slog.Error("A folder with this name already exists")
+		templateRoot, err := resolveTemplate(initTemplate)
+		if err != nil {
+			slog.Error("Failed to find template", "template", initTemplate, "error", err)
 			return
 		}
 
-		slog.Debug("Creating blog directory", "blogName", blogName)
-		err := os.MkdirAll(fmt.Sprintf("./%s", blogName), 0755)
+		slog.Info("⚙️ Initializing your Velcro blog...", "template", initTemplate)
+
+		if initHere {
+			slog.Debug("Checking the current directory is empty")
+			empty, err := isEmptyDir(destDir)
+			if err != nil {
+				slog.Error("Failed to read the current directory", "error", err)
+				return
+			}
+			if !empty {
+				slog.Error("The current directory is not empty")
+				return
+			}
+		} else {
+			slog.Debug("Checking if blog directory exists", "blogName", blogName)
+			if _, err := os.Stat(destDir); err == nil {
+				slog.Error("A folder with this name already exists")
+				return
+			}
+
+			slog.Debug("Creating blog directory", "blogName", blogName)
+			err = os.MkdirAll(destDir, 0755)
+			if err != nil {
+				slog.Error("Failed to create blog directory", "error", err)
+				return
+			}
+		}
+
+		title := initTitle
+		if title == "" {
+			title = titleFromName(blogName)
+		}
+		author := initAuthor
+		if author == "" {
+			author = title
+		}
+
+		values := templateValues(blogName, title, author)
+
+		slog.Debug("Copying template files", "blogName", blogName)
+		files, dirs, err := renderTemplate(templateRoot, values)
 		if err != nil {
-			slog.Error("Failed to create blog directory", "error", err)
+			slog.Error("Failed to read template files", "error", err)
 			return
 		}
-		slog.Debug("Copying template files", "blogName", blogName)
-		err = copyTemplateFiles(blogName)
+		err = writeTemplateFiles(destDir, dirs, files)
 		if err != nil {
 			slog.Error("Failed to copy template files", "error", err)
 			return
 		}
 
+		// Keep a copy of the template so velcro upgrade can tell which files were changed
+		templateName := initTemplate
+		if !slices.Contains(builtinTemplates(), templateName) {
+			templateName, _ = filepath.Abs(templateName)
+		}
+		err = upgrade.WriteSnapshot(destDir, upgrade.Manifest{Template: templateName, Values: values}, files)
+		if err != nil {
+			slog.Error("Failed to save template snapshot", "error", err)
+			return
+		}
+
 		slog.Info("✅ Blog initialized successfully!\n")
 
 		slog.Info("👀 Getting started with your new blog")
-		slog.Info(fmt.Sprintf("1. cd ./%s", blogName))
-		slog.Info("2. velcro build")
-		slog.Info("3. velcro serve")
+		if initHere {
+			slog.Info("1. velcro build")
+			slog.Info("2. velcro serve")
+		} else {
+			slog.Info(fmt.Sprintf("1. cd ./%s", blogName))
+			slog.Info("2. velcro build")
+			slog.Info("3. velcro serve")
+		}
 	},
 }
 
-func copyTemplateFiles(blogName string) error {
-	destDir := filepath.Join(".", blogName)
-	return fs.WalkDir(templateFS, "init_template", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+// builtinTemplates lists the names of the starter templates embedded in the binary.
+func builtinTemplates() []string {
+	entries, err := templateFS.ReadDir("init_template")
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
 		}
+	}
+	return names
+}
 
-		if strings.HasSuffix(d.Name(), ".gitkeep") {
-			return nil
+// resolveTemplate returns the file system for a built-in template name or a path
+// to a local template directory.
+func resolveTemplate(template string) (fs.FS, error) {
+	if slices.Contains(builtinTemplates(), template) {
+		return fs.Sub(templateFS, "init_template/"+template)
+	}
+
+	info, err := os.Stat(template)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a built-in template (%s) or a directory", template, strings.Join(builtinTemplates(), ", "))
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%q is not a directory", template)
+	}
+
+	return os.DirFS(template), nil
+}
+
+// isEmptyDir reports whether dir has no entries apart from a .git directory.
+func isEmptyDir(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		if entry.Name() != ".git" {
+			return false, nil
 		}
+	}
+	return true, nil
+}
+
+// templateValues returns the placeholders filled in when rendering a template.
+func templateValues(siteName, title, author string) map[string]string {
+	return map[string]string{
+		"site_name": siteName,
+		"title":     title,
+		"author":    author,
+		"year":      strconv.Itoa(time.Now().Year()),
+		"date":      time.Now().Format(time.DateOnly),
+	}
+}
 
-		relPath, err := filepath.Rel("init_template", path)
+// renderTemplate reads every file of a template, substituting placeholders such as
+// {{title}} in text files. Directories are returned too so empty ones still get created.
+func renderTemplate(templateRoot fs.FS, values map[string]string) (map[string][]byte, []string, error) {
+	// Placeholders in TOML files sit inside basic strings
+	tomlEscaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	var pairs, htmlPairs, tomlPairs []string
+	for key, value := range values {
+		pairs = append(pairs, "{{"+key+"}}", value)
+		htmlPairs = append(htmlPairs, "{{"+key+"}}", html.EscapeString(value))
+		tomlPairs = append(tomlPairs, "{{"+key+"}}", tomlEscaper.Replace(value))
+	}
+	replacer := strings.NewReplacer(pairs...)
+	htmlReplacer := strings.NewReplacer(htmlPairs...)
+	tomlReplacer := strings.NewReplacer(tomlPairs...)
+
+	files := make(map[string][]byte)
+	var dirs []string
+
+	err := fs.WalkDir(templateRoot, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if relPath == "." {
+		if strings.HasSuffix(d.Name(), ".gitkeep") {
 			return nil
 		}
 
-		destPath := filepath.Join(destDir, relPath)
+		if path == "." {
+			return nil
+		}
+
+		// A local template may be an existing site with its own snapshot
+		if d.IsDir() && d.Name() == ".velcro" {
+			return fs.SkipDir
+		}
 
 		if d.IsDir() {
-			slog.Debug("Creating directory", "path", destPath)
-			return os.MkdirAll(destPath, 0755)
+			dirs = append(dirs, path)
+			return nil
 		}
 
-		slog.Debug("Copying file", "from", path, "to", destPath)
-		srcFile, err := templateFS.Open(path)
+		content, err := fs.ReadFile(templateRoot, path)
 		if err != nil {
 			return err
 		}
-		defer srcFile.Close()
 
-		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		// Substitute placeholders in text files, escaping values that end up inside markup
+		switch ext := strings.ToLower(filepath.Ext(path)); {
+		case ext == ".html" || ext == ".htm" || ext == ".svg" || ext == ".xml":
+			content = []byte(htmlReplacer.Replace(string(content)))
+		case ext == ".toml":
+			content = []byte(tomlReplacer.Replace(string(content)))
+		case slices.Contains(templateTextExtensions, ext):
+			content = []byte(replacer.Replace(string(content)))
+		}
+
+		files[path] = content
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return files, dirs, nil
+}
+
+func writeTemplateFiles(destDir string, dirs []string, files map[string][]byte) error {
+	for _, dir := range dirs {
+		destPath := filepath.Join(destDir, filepath.FromSlash(dir))
+		slog.Debug("Creating directory", "path", destPath)
+		if err := os.MkdirAll(destPath, 0755); err != nil {
 			return err
 		}
+	}
 
-		dstFile, err := os.Create(destPath)
-		if err != nil {
+	for path, content := range files {
+		destPath := filepath.Join(destDir, filepath.FromSlash(path))
+		slog.Debug("Writing file", "path", destPath)
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 			return err
 		}
-		defer dstFile.Close()
+		if err := os.WriteFile(destPath, content, 0644); err != nil {
+			return err
+		}
+	}
 
-		_, err = io.Copy(dstFile, srcFile)
-		return err
-	})
+	return nil
 }
 
 func init() {
+	initCmd.Flags().StringVarP(&initTemplate, "template", "t", "blog", "built-in template name or path to a template directory")
+	initCmd.Flags().StringVar(&initTitle, "title", "", "title of your site (defaults to the blog name)")
+	initCmd.Flags().StringVar(&initAuthor, "author", "", "author of your site (defaults to the title)")
+	initCmd.Flags().BoolVar(&initHere, "here", false, "initialize into the current (empty) directory")
 	rootCmd.AddCommand(initCmd)
 }
@@ -0,0 +1,136 @@
+// Client-side search over search-index.json. Every word typed has to match a word
+// in the post, either as the start of the word or, for longer words, with a typo.
+document.querySelectorAll(".search").forEach(function (search) {
+    var input = search.querySelector("input");
+    var results = search.querySelector(".search-results");
+    var indexURL = new URL(search.dataset.index, document.baseURI);
+    var index = null;
+
+    // Matches in the title count for more than matches in the text
+    var fields = [
+        { name: "title", weight: 10 },
+        { name: "tags", weight: 6 },
+        { name: "headings", weight: 4 },
+        { name: "description", weight: 3 },
+        { name: "text", weight: 1 },
+    ];
+
+    function words(text) {
+        return text.toLowerCase().split(/[^\p{L}\p{N}]+/u).filter(Boolean);
+    }
+
+    function distance(a, b) {
+        var previous = [];
+        for (var j = 0; j <= b.length; j++) previous[j] = j;
+        for (var i = 1; i <= a.length; i++) {
+            var current = [i];
+            for (var j = 1; j <= b.length; j++) {
+                var cost = a[i - 1] === b[j - 1] ? 0 : 1;
+                current[j] = Math.min(previous[j] + 1, current[j - 1] + 1, previous[j - 1] + cost);
+            }
+            previous = current;
+        }
+        return previous[b.length];
+    }
+
+    // Scores how well a word from the query matches a word from a post
+    function matchWord(term, word) {
+        if (word.startsWith(term)) return 1;
+        if (term.length < 4) return 0;
+        var allowed = term.length < 7 ? 1 : 2;
+        return distance(term, word.slice(0, term.length)) <= allowed ? 0.5 : 0;
+    }
+
+    function prepare(entries) {
+        return entries.map(function (entry) {
+            var prepared = { entry: entry, fields: [] };
+            fields.forEach(function (field) {
+                var value = entry[field.name] || "";
+                if (Array.isArray(value)) value = value.join(" ");
+                prepared.fields.push({ weight: field.weight, words: words(value) });
+            });
+            return prepared;
+        });
+    }
+
+    function score(prepared, terms) {
+        var total = 0;
+        for (var t = 0; t < terms.length; t++) {
+            var best = 0;
+            prepared.fields.forEach(function (field) {
+                for (var w = 0; w < field.words.length; w++) {
+                    best = Math.max(best, matchWord(terms[t], field.words[w]) * field.weight);
+                }
+            });
+            if (best === 0) return 0;
+            total += best;
+        }
+        return total;
+    }
+
+    function render(matches) {
+        results.replaceChildren();
+        matches.slice(0, 10).forEach(function (match) {
+            var item = document.createElement("li");
+            var link = document.createElement("a");
+            link.href = new URL(match.entry.url, indexURL).href;
+            link.textContent = match.entry.title || match.entry.url;
+            item.appendChild(link);
+
+            if (match.entry.description) {
+                var description = document.createElement("p");
+                description.textContent = match.entry.description;
+                item.appendChild(description);
+            }
+            results.appendChild(item);
+        });
+
+        if (matches.length === 0) {
+            var empty = document.createElement("li");
+            empty.textContent = "No posts found";
+            results.appendChild(empty);
+        }
+        results.hidden = false;
+    }
+
+    function run() {
+        var terms = words(input.value);
+        if (terms.length === 0 || index === null) {
+            results.hidden = true;
+            return;
+        }
+
+        var matches = [];
+        index.forEach(function (prepared) {
+            var s = score(prepared, terms);
+            if (s > 0) matches.push({ entry: prepared.entry, score: s });
+        });
+        matches.sort(function (a, b) { return b.score - a.score; });
+        render(matches);
+    }
+
+    // The index is only downloaded once someone starts searching
+    input.addEventListener("focus", function () {
+        if (index !== null) return;
+        fetch(indexURL)
+            .then(function (response) {
+                if (!response.ok) throw new Error(response.status + " " + response.statusText);
+                return response.json();
+            })
+            .then(function (entries) {
+                index = prepare(entries);
+                run();
+            })
+            .catch(function (error) {
+                // Usually search_index is off in site.config.toml, so the file was never written
+                console.error("Failed to load " + indexURL + ":", error);
+                results.replaceChildren();
+                var failed = document.createElement("li");
+                failed.textContent = "Search is not available";
+                results.appendChild(failed);
+                results.hidden = false;
+            });
+    }, { once: true });
+
+    input.addEventListener("input", run);
+});
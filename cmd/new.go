@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"embed"
+	"fmt"
+	"html"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"velcro/internal/build"
+	"velcro/internal/siteconfig"
+
+	"github.com/spf13/cobra"
+)
+
+//go:embed scaffolds
+var scaffoldsFS embed.FS
+
+var (
+	newConfigPath  string
+	newDescription string
+	newIsDraft     bool
+	newMarkdown    bool
+)
+
+var newCmd = &cobra.Command{
+	Use:   "new",
+	Short: "Create a new post, page or component",
+	Long: `Creates a new post, page or component from a scaffold.
+
+The site config is looked up in path (the current directory by default) and
+then in each of its parents, as for velcro build.
+
+Scaffolds can be overridden by placing post.html, post.md, page.html or
+component.html/.css/.js in your scaffolds directory (dirs.scaffolds).`,
+}
+
+var newPostCmd = &cobra.Command{
+	Use:   "post <title> [path]",
+	Short: "Create a new post",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		title := args[0]
+
+		config, siteDir, err := loadNewConfig(args[1:])
+		if err != nil {
+			slog.Error("Failed to load site config", "error", err)
+			return
+		}
+
+		slug := build.Slugify(title)
+		if slug == "" {
+			slog.Error("The post title must contain at least one letter or number")
+			return
+		}
+
+		if newIsDraft {
+			if config.DraftPrefix == "" {
+				slog.Error("Cannot create a draft, draft_prefix is not set in your site config")
+				return
+			}
+			slug = config.DraftPrefix + slug
+		}
+
+		ext := ".html"
+		if newMarkdown {
+			ext = ".md"
+		}
+
+		postDir := filepath.Join(siteconfig.ResolvePath(siteDir, config.Dirs.Posts), slug)
+		err = writeScaffold(config, siteDir, "post"+ext, filepath.Join(postDir, "index"+ext), scaffoldValues(title, slug))
+		if err != nil {
+			slog.Error("Failed to create post", "error", err)
+			return
+		}
+
+		slog.Info("✅ Post created", "path", postDir)
+	},
+}
+
+var newPageCmd = &cobra.Command{
+	Use:   "page <name> [path]",
+	Short: "Create a new page",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+
+		if !validScaffoldName(name) {
+			slog.Error("The page name must contain only A-Z, a-z, 0-9, hyphens, and underscores")
+			return
+		}
+
+		config, siteDir, err := loadNewConfig(args[1:])
+		if err != nil {
+			slog.Error("Failed to load site config", "error", err)
+			return
+		}
+
+		pageDir := filepath.Join(siteconfig.ResolvePath(siteDir, config.Dirs.Pages), name)
+		err = writeScaffold(config, siteDir, "page.html", filepath.Join(pageDir, "index.html"), scaffoldValues(titleFromName(name), name))
+		if err != nil {
+			slog.Error("Failed to create page", "error", err)
+			return
+		}
+
+		slog.Info("✅ Page created", "path", pageDir)
+	},
+}
+
+var newComponentCmd = &cobra.Command{
+	Use:   "component <name> [path]",
+	Short: "Create a new component (HTML, CSS and JS)",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+
+		if !validScaffoldName(name) {
+			slog.Error("The component name must contain only A-Z, a-z, 0-9, hyphens, and underscores")
+			return
+		}
+
+		config, siteDir, err := loadNewConfig(args[1:])
+		if err != nil {
+			slog.Error("Failed to load site config", "error", err)
+			return
+		}
+
+		componentsDir := siteconfig.ResolvePath(siteDir, config.Dirs.Components)
+		values := scaffoldValues(titleFromName(name), name)
+		for _, ext := range []string{".html", ".css", ".js"} {
+			err = writeScaffold(config, siteDir, "component"+ext, filepath.Join(componentsDir, name+ext), values)
+			if err != nil {
+				slog.Error("Failed to create component", "error", err)
+				return
+			}
+		}
+
+		slog.Info("✅ Component created", "name", name, "path", componentsDir)
+		slog.Info(fmt.Sprintf(`Include it with: <!-- include="@components/%s.html" -->`, name))
+	},
+}
+
+// loadNewConfig loads the site config found from --config or the optional path
+// argument, returning it along with the directory it is in.
+func loadNewConfig(args []string) (*siteconfig.SiteConfig, string, error) {
+	configPath, err := findConfigPath(newConfigPath, args)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config, err := siteconfig.LoadSiteConfig(configPath, siteconfig.Overrides{})
+	if err != nil {
+		return nil, "", err
+	}
+	return config, filepath.Dir(configPath), nil
+}
+
+// scaffoldValues returns the placeholder values available to scaffolds.
+func scaffoldValues(title, slug string) map[string]string {
+	return map[string]string{
+		"title":       title,
+		"description": newDescription,
+		"date":        time.Now().Format(time.DateOnly),
+		"slug":        slug,
+		"name":        slug,
+	}
+}
+
+// writeScaffold renders a scaffold into dstPath, refusing to overwrite existing files.
+// A scaffold in the project's scaffolds directory takes precedence over the built-in one.
+func writeScaffold(config *siteconfig.SiteConfig, siteDir, name, dstPath string, values map[string]string) error {
+	if _, err := os.Stat(dstPath); err == nil {
+		return fmt.Errorf("%s already exists", dstPath)
+	}
+
+	scaffold, err := readScaffold(config, siteDir, name)
+	if err != nil {
+		return err
+	}
+
+	// Values end up inside HTML, so escape them there. Markdown scaffolds start
+	// with an HTML <head> too.
+	var pairs []string
+	for key, value := range values {
+		if strings.HasSuffix(name, ".html") || strings.HasSuffix(name, ".md") {
+			value = html.EscapeString(value)
+		}
+		pairs = append(pairs, "{{"+key+"}}", value)
+	}
+	rendered := strings.NewReplacer(pairs...).Replace(string(scaffold))
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+
+	slog.Debug("Writing file", "path", dstPath)
+	return os.WriteFile(dstPath, []byte(rendered), 0644)
+}
+
+// readScaffold returns the project's copy of a scaffold if there is one, falling
+// back to the built-in scaffold.
+func readScaffold(config *siteconfig.SiteConfig, siteDir, name string) ([]byte, error) {
+	if config.Dirs.Scaffolds != "" {
+		projectScaffold := filepath.Join(siteconfig.ResolvePath(siteDir, config.Dirs.Scaffolds), name)
+		content, err := os.ReadFile(projectScaffold)
+		if err == nil {
+			slog.Debug("Using project scaffold", "scaffold", projectScaffold)
+			return content, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	slog.Debug("Using built-in scaffold", "scaffold", name)
+	return scaffoldsFS.ReadFile("scaffolds/" + name)
+}
+
+// titleFromName turns a name such as "about-me" into "About Me".
+func titleFromName(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' })
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+func validScaffoldName(name string) bool {
+	match, _ := regexp.MatchString("^[a-zA-Z0-9-_]+$", name)
+	return match
+}
+
+func init() {
+	newCmd.PersistentFlags().StringVarP(&newConfigPath, "config", "c", "", "path to the site config (default: search upwards for "+siteconfig.FileName+")")
+	newCmd.PersistentFlags().StringVarP(&newDescription, "description", "d", "", "description for the new post or page")
+	newPostCmd.Flags().BoolVar(&newIsDraft, "draft", false, "prefix the post folder with draft_prefix")
+	newPostCmd.Flags().BoolVarP(&newMarkdown, "markdown", "m", false, "write the post in Markdown (index.md) instead of HTML")
+
+	newCmd.AddCommand(newPostCmd)
+	newCmd.AddCommand(newPageCmd)
+	newCmd.AddCommand(newComponentCmd)
+	rootCmd.AddCommand(newCmd)
+}
@@ -0,0 +1 @@
+// Scripts for the {{name}} component. Injected into every page that includes it.
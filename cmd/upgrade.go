@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"velcro/internal/siteconfig"
+	"velcro/internal/upgrade"
+
+	"github.com/spf13/cobra"
+)
+
+// upgradeBaseline is the template velcro init used before it kept a snapshot, the
+// base for sites created back then.
+//
+//go:embed all:upgrade_baseline
+var upgradeBaseline embed.FS
+
+var upgradeApply bool
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade [path]",
+	Short: "Upgrade your site to the latest config and template",
+	Long: `Migrates site.config.toml to the latest config version and compares your
+files against the latest version of the template your site was created from.
+
+Files you have not modified are updated, files you have modified are left alone
+and the template's changes to them are shown so you can merge them by hand.
+Sites created before velcro kept a copy of their template are compared against
+the template of those versions.
+Nothing is written unless --apply is passed.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		out := cmd.OutOrStdout()
+
+		siteConfigPath, err := findConfigPath("", args)
+		if err != nil {
+			slog.Error("Failed to find site config", "error", err)
+			return
+		}
+		rootDir := filepath.Dir(siteConfigPath)
+
+		// The template the site was created from, rendered with the site's values
+		manifest, base, err := upgrade.ReadSnapshot(rootDir)
+		if errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("No template snapshot found, comparing against the template of earlier velcro versions", "path", filepath.Join(rootDir, upgrade.ManifestPath))
+			manifest, base, err = baselineSnapshot(rootDir)
+			if err != nil {
+				slog.Error("Failed to read earlier template", "error", err)
+				return
+			}
+		} else if err != nil {
+			slog.Error("Failed to read template snapshot", "error", err)
+			return
+		}
+
+		templateRoot, err := resolveTemplate(manifest.Template)
+		if err != nil {
+			slog.Error("Failed to find template", "template", manifest.Template, "error", err)
+			return
+		}
+
+		theirs, _, err := renderTemplate(templateRoot, manifest.Values)
+		if err != nil {
+			slog.Error("Failed to read template files", "error", err)
+			return
+		}
+
+		// Migrate the site config, new keys follow the template's own config
+		configContent, err := os.ReadFile(siteConfigPath)
+		if err != nil {
+			slog.Error("Failed to read site config", "error", err)
+			return
+		}
+
+		migrated, applied, err := siteconfig.MigrateConfig(string(configContent), string(theirs[siteconfig.FileName]))
+		if err != nil {
+			slog.Error("Failed to migrate site config", "error", err)
+			return
+		}
+
+		if len(applied) == 0 {
+			slog.Info("Site config is up to date", "version", siteconfig.CurrentVersion)
+		} else {
+			for _, migration := range applied {
+				slog.Info("Config migration", "version", migration.Version, "description", migration.Description)
+			}
+			fmt.Fprint(out, upgrade.UnifiedDiff(configContent, []byte(migrated), siteconfig.FileName, siteconfig.FileName))
+		}
+
+		// Compare the template files
+		changes, err := upgrade.Plan(rootDir, base, theirs, siteconfig.FileName)
+		if err != nil {
+			slog.Error("Failed to compare template files", "error", err)
+			return
+		}
+
+		if len(changes) == 0 {
+			slog.Info("Template files are up to date", "template", manifest.Template)
+		}
+		for _, change := range changes {
+			switch change.Status {
+			case upgrade.Conflict:
+				slog.Warn("You have modified this file, merge the template changes by hand", "file", change.Path)
+			default:
+				slog.Info("Template file", "file", change.Path, "change", change.Status)
+			}
+			fmt.Fprint(out, change.Diff)
+		}
+
+		if !upgradeApply {
+			applicable := slices.ContainsFunc(changes, func(change upgrade.FileChange) bool {
+				return change.Status != upgrade.Conflict
+			})
+			if len(applied) > 0 || applicable {
+				slog.Info("Run velcro upgrade --apply to make these changes")
+			}
+			return
+		}
+
+		if len(applied) > 0 {
+			err = os.WriteFile(siteConfigPath, []byte(migrated), 0644)
+			if err != nil {
+				slog.Error("Failed to write site config", "error", err)
+				return
+			}
+		}
+
+		err = upgrade.Apply(rootDir, changes)
+		if err != nil {
+			slog.Error("Failed to update template files", "error", err)
+			return
+		}
+
+		// Files with conflicts keep their old snapshot so they are reported again next time
+		snapshot := theirs
+		for _, change := range changes {
+			if change.Status != upgrade.Conflict {
+				continue
+			}
+			if baseContent, ok := base[change.Path]; ok {
+				snapshot[change.Path] = baseContent
+			} else {
+				delete(snapshot, change.Path)
+			}
+		}
+
+		err = upgrade.WriteSnapshot(rootDir, *manifest, snapshot)
+		if err != nil {
+			slog.Error("Failed to save template snapshot", "error", err)
+			return
+		}
+
+		slog.Info("✅ Site upgraded successfully")
+	},
+}
+
+// baselineSnapshot stands in for the snapshot of a site created before velcro init
+// kept one. Those sites all started from what is now the blog template.
+func baselineSnapshot(rootDir string) (*upgrade.Manifest, map[string][]byte, error) {
+	baselineRoot, err := fs.Sub(upgradeBaseline, "upgrade_baseline")
+	if err != nil {
+		return nil, nil, err
+	}
+	base, _, err := renderTemplate(baselineRoot, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	absoluteRootDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	siteName := filepath.Base(absoluteRootDir)
+	title := titleFromName(siteName)
+	manifest := &upgrade.Manifest{Template: "blog", Values: templateValues(siteName, title, title)}
+	return manifest, base, nil
+}
+
+func init() {
+	upgradeCmd.Flags().BoolVar(&upgradeApply, "apply", false, "write the changes instead of only showing them")
+	rootCmd.AddCommand(upgradeCmd)
+}
@@ -0,0 +1,9 @@
+/*
+preload.js -> This file is executed before the HTML is loaded.
+index.js -> This file is executed after the HTML is loaded.
+*/
+
+// Global JavaScript is applied to all pages across your blog.
+// It lets you have full control over any custom behavior for your blog.
+// For example, putting your analytics here is a good idea.
+console.log("Hello from GLOBAL preload.js!");
\ No newline at end of file
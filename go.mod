@@ -6,9 +6,10 @@ require (
 	github.com/BurntSushi/toml v1.5.0
 	github.com/lmittmann/tint v1.1.2
 	github.com/spf13/cobra v1.10.1
+	github.com/spf13/pflag v1.0.9
+	github.com/yuin/goldmark v1.8.2
+	golang.org/x/image v0.25.0
+	golang.org/x/net v0.47.0
 )
 
-require (
-	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.9 // indirect
-)
+require github.com/inconshreveable/mousetrap v1.1.0 // indirect
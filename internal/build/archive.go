@@ -0,0 +1,141 @@
+package build
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"velcro/internal/siteconfig"
+)
+
+// archiveMonth is the posts of one month, newest first.
+type archiveMonth struct {
+	month time.Month
+	posts []pageMeta
+}
+
+// archiveYear is the posts of one year, grouped by month.
+type archiveYear struct {
+	year   int
+	posts  []pageMeta
+	months []archiveMonth
+}
+
+// groupPostsByDate groups posts by the year and month of their date, newest first.
+// Posts without a date are left out. posts must already be sorted newest first.
+func groupPostsByDate(posts []pageMeta) []archiveYear {
+	var years []archiveYear
+	for _, post := range posts {
+		if post.Time.IsZero() {
+			continue
+		}
+
+		if len(years) == 0 || years[len(years)-1].year != post.Time.Year() {
+			years = append(years, archiveYear{year: post.Time.Year()})
+		}
+		year := &years[len(years)-1]
+		year.posts = append(year.posts, post)
+
+		if len(year.months) == 0 || year.months[len(year.months)-1].month != post.Time.Month() {
+			year.months = append(year.months, archiveMonth{month: post.Time.Month()})
+		}
+		month := &year.months[len(year.months)-1]
+		month.posts = append(month.posts, post)
+	}
+	return years
+}
+
+// buildArchives writes archive/index.html, archive/<year>/index.html and
+// archive/<year>/<month>/index.html. They are rendered like pages through the
+// "archive" layout if there is one, or base_html otherwise.
+func buildArchives(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
+	err := removeListPages("archive", cfg, opts)
+	if err != nil {
+		return err
+	}
+	if !cfg.Archives {
+		return nil
+	}
+
+	years := groupPostsByDate(opts.posts)
+	if len(years) == 0 {
+		return nil
+	}
+
+	var yearLinks strings.Builder
+	for _, year := range years {
+		fmt.Fprintf(&yearLinks, "        <li><a href=\"@archive/%d\">%d</a> (%d)</li>\n", year.year, year.year, len(year.posts))
+	}
+	err = renderListPage("archive", "Archive", yearLinks.String(), nil, cfg, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, year := range years {
+		var monthLinks strings.Builder
+		for _, month := range year.months {
+			fmt.Fprintf(&monthLinks, "        <li><a href=\"@archive/%d/%02d\">%s</a> (%d)</li>\n", year.year, int(month.month), month.month, len(month.posts))
+		}
+		err := renderListPage("archive", fmt.Sprint(year.year), monthLinks.String(), year.posts, cfg, opts, fmt.Sprint(year.year))
+		if err != nil {
+			return err
+		}
+
+		for _, month := range year.months {
+			title := fmt.Sprintf("%s %d", month.month, year.year)
+			err := renderListPage("archive", title, "", month.posts, cfg, opts, fmt.Sprint(year.year), fmt.Sprintf("%02d", int(month.month)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeListPages removes the <section>/ pages of an earlier build so posts that
+// are gone or no longer published stop being listed. A page of the project with
+// the same name is left alone.
+func removeListPages(section string, cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
+	if _, err := os.Stat(filepath.Join(opts.resolve(cfg.Dirs.Pages), section)); err == nil {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(opts.resolve(cfg.OutputDir), section))
+}
+
+// renderListPage renders a generated page below <section>/ in the output
+// directory, such as archive/2025/, with a list of links (already HTML) followed by
+// its posts. The page goes through the layout named after the section if there is
+// one, or base_html otherwise.
+func renderListPage(section, title, links string, posts []pageMeta, cfg *siteconfig.SiteConfig, opts *BuildOptions, dirs ...string) error {
+	outputPath := filepath.Join(append(append([]string{section}, dirs...), "index.html")...)
+	dst := filepath.Join(opts.resolve(cfg.OutputDir), outputPath)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "<head>\n    <title>%s</title>\n</head>\n\n<body>\n    <h1>%s</h1>\n", html.EscapeString(title), html.EscapeString(title))
+	if links != "" {
+		fmt.Fprintf(&body, "    <ul class=\"%s\">\n%s    </ul>\n", section, links)
+	}
+
+	var list *listing
+	if len(posts) > 0 {
+		body.WriteString("    <!-- include=\"@postlist\" -->\n")
+		list = &listing{
+			posts: posts,
+			page:  1,
+			pages: 1,
+			hrefs: []string{pageMeta{Section: section, Path: filepath.ToSlash(outputPath)}.Href()},
+		}
+	}
+	body.WriteString("</body>\n")
+
+	// The page has no source file, so relative paths resolve as if it were a page
+	// at the same place in the pages directory
+	src := filepath.Join(opts.resolve(cfg.Dirs.Pages), outputPath)
+	return renderPage([]byte(body.String()), src, dst, section, section, list, cfg, opts)
+}
@@ -4,20 +4,68 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
+	"unicode/utf8"
 	"velcro/internal/siteconfig"
+
+	"golang.org/x/net/html"
 )
 
 type BuildOptions struct {
+	// RootDir is the directory containing the site config. Relative paths in the
+	// config are resolved against it.
 	RootDir string
+	// Future builds posts dated after Now instead of holding them back.
+	Future bool
+	// Now is the time posts are published against, the current time if zero.
+	Now time.Time
+
+	// values holds the site and data variables for value comments, loaded by Run
+	values map[string]any
+	// posts lists every published post newest first, loaded by Run
+	posts []pageMeta
+	// unpublished holds the IDs of posts that are drafts, scheduled or expired
+	unpublished map[string]bool
+	// imageVariants holds the resized images already written by this build
+	imageVariants map[string]bool
+}
+
+// resolve returns the location of a path from the site config.
+func (o *BuildOptions) resolve(p string) string {
+	return siteconfig.ResolvePath(o.RootDir, p)
 }
 
 func Run(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
+	slog.Info("Loading data...")
+	values, err := loadValues(cfg, opts)
+	if err != nil {
+		slog.Error("Failed to load data", "error", err)
+		return err
+	}
+	opts.values = values
+	opts.imageVariants = make(map[string]bool)
+
+	posts, err := collectPosts(cfg, opts)
+	if err != nil {
+		slog.Error("Failed to read posts", "error", err)
+		return err
+	}
+
+	// Drafts, scheduled and expired posts are left out of every stage below
+	opts.posts, opts.unpublished, err = filterPublished(posts, cfg, opts)
+	if err != nil {
+		slog.Error("Failed to read posts", "error", err)
+		return err
+	}
+
 	slog.Info("Building posts...")
-	err := buildPosts(cfg, opts)
+	err = buildPosts(cfg, opts)
 	if err != nil {
 		slog.Error("Failed to build posts", "error", err)
 		return err
@@ -51,6 +99,34 @@ func Run(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 		return err
 	}
 
+	slog.Info("Building archives...")
+	err = buildArchives(cfg, opts)
+	if err != nil {
+		slog.Error("Failed to build archives", "error", err)
+		return err
+	}
+
+	slog.Info("Building series...")
+	err = buildSeries(cfg, opts)
+	if err != nil {
+		slog.Error("Failed to build series", "error", err)
+		return err
+	}
+
+	slog.Info("Building search index...")
+	err = buildSearchIndex(cfg, opts)
+	if err != nil {
+		slog.Error("Failed to build search index", "error", err)
+		return err
+	}
+
+	slog.Info("Building posts.json...")
+	err = buildPostsJSON(cfg, opts)
+	if err != nil {
+		slog.Error("Failed to build posts.json", "error", err)
+		return err
+	}
+
 	slog.Info("Building component assets...")
 	err = buildComponentAssets(cfg, opts)
 	if err != nil {
@@ -70,14 +146,14 @@ func Run(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 
 func buildAssets(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 	assetsDir := cfg.Dirs.Assets
-	absoluteAssetsDir := filepath.Join(opts.RootDir, assetsDir)
+	absoluteAssetsDir := opts.resolve(assetsDir)
 
 	if _, err := os.Stat(absoluteAssetsDir); os.IsNotExist(err) {
 		return nil
 	}
 
 	// Create output assets directory
-	outputAssetsDir := filepath.Join(opts.RootDir, cfg.OutputDir, "assets")
+	outputAssetsDir := filepath.Join(opts.resolve(cfg.OutputDir), "assets")
 	err := os.MkdirAll(outputAssetsDir, 0755)
 	if err != nil {
 		return err
@@ -88,13 +164,13 @@ func buildAssets(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 
 func buildScripts(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 	scriptsDir := cfg.Dirs.Scripts
-	absoluteScriptsDir := filepath.Join(opts.RootDir, scriptsDir)
+	absoluteScriptsDir := opts.resolve(scriptsDir)
 
 	if _, err := os.Stat(absoluteScriptsDir); os.IsNotExist(err) {
 		return nil
 	}
 
-	outputScriptsDir := filepath.Join(opts.RootDir, cfg.OutputDir, "scripts")
+	outputScriptsDir := filepath.Join(opts.resolve(cfg.OutputDir), "scripts")
 	err := os.MkdirAll(outputScriptsDir, 0755)
 	if err != nil {
 		return err
@@ -105,7 +181,7 @@ func buildScripts(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 
 func buildStyles(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 	stylesDir := cfg.Dirs.Styles
-	absoluteStylesDir := filepath.Join(opts.RootDir, stylesDir)
+	absoluteStylesDir := opts.resolve(stylesDir)
 
 	// Check if styles directory exists
 	if _, err := os.Stat(absoluteStylesDir); os.IsNotExist(err) {
@@ -114,7 +190,7 @@ func buildStyles(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 	}
 
 	// Create output styles directory
-	outputStylesDir := filepath.Join(opts.RootDir, cfg.OutputDir, "styles")
+	outputStylesDir := filepath.Join(opts.resolve(cfg.OutputDir), "styles")
 	err := os.MkdirAll(outputStylesDir, 0755)
 	if err != nil {
 		return err
@@ -126,7 +202,7 @@ func buildStyles(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 
 func buildPages(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 	pagesDir := cfg.Dirs.Pages
-	absolutePagesDir := filepath.Join(opts.RootDir, pagesDir)
+	absolutePagesDir := opts.resolve(pagesDir)
 
 	pages, err := os.ReadDir(absolutePagesDir)
 	if err != nil {
@@ -140,10 +216,10 @@ func buildPages(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 			var outputPageDir string
 			if page.Name() == "index" {
 				// index page goes to the root of the output directory
-				outputPageDir = filepath.Join(opts.RootDir, cfg.OutputDir)
+				outputPageDir = opts.resolve(cfg.OutputDir)
 			} else {
 				// other pages go to {outputDir}/{pageName}
-				outputPageDir = filepath.Join(opts.RootDir, cfg.OutputDir, page.Name())
+				outputPageDir = filepath.Join(opts.resolve(cfg.OutputDir), page.Name())
 			}
 
 			err := os.MkdirAll(outputPageDir, 0755)
@@ -163,7 +239,7 @@ func buildPages(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 
 func buildPosts(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 	postsDir := cfg.Dirs.Posts
-	absolutePostsDir := filepath.Join(opts.RootDir, postsDir)
+	absolutePostsDir := opts.resolve(postsDir)
 
 	posts, err := os.ReadDir(absolutePostsDir)
 	if err != nil {
@@ -171,9 +247,18 @@ func buildPosts(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 	}
 
 	for _, post := range posts {
+		if opts.unpublished[post.Name()] {
+			// Remove what an earlier build published, e.g. before the post expired
+			err := os.RemoveAll(filepath.Join(opts.resolve(cfg.OutputDir), "posts", post.Name()))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
 		if post.IsDir() {
 			// Create the folder in the output directory
-			outputPostDir := filepath.Join(opts.RootDir, cfg.OutputDir, "posts", post.Name())
+			outputPostDir := filepath.Join(opts.resolve(cfg.OutputDir), "posts", post.Name())
 			err := os.MkdirAll(outputPostDir, 0755)
 			if err != nil {
 				return err
@@ -191,7 +276,7 @@ func buildPosts(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 }
 
 func buildComponentAssets(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
-	componentsDir := filepath.Join(opts.RootDir, cfg.Dirs.Components)
+	componentsDir := opts.resolve(cfg.Dirs.Components)
 
 	// Check if components directory exists
 	if _, err := os.Stat(componentsDir); os.IsNotExist(err) {
@@ -222,14 +307,18 @@ func buildComponentAssets(cfg *siteconfig.SiteConfig, opts *BuildOptions) error
 	}
 
 	// Copy component CSS and JS files to output
-	outputStylesDir := filepath.Join(opts.RootDir, cfg.OutputDir, "styles")
-	outputScriptsDir := filepath.Join(opts.RootDir, cfg.OutputDir, "scripts")
+	outputStylesDir := filepath.Join(opts.resolve(cfg.OutputDir), "styles")
+	outputScriptsDir := filepath.Join(opts.resolve(cfg.OutputDir), "scripts")
 
 	for componentName := range componentNames {
 		// Copy CSS file if it exists
 		cssPath := filepath.Join(componentsDir, componentName+".css")
 		if _, err := os.Stat(cssPath); err == nil {
-			err := os.MkdirAll(outputStylesDir, 0755)
+			err := confinePath(cssPath, componentsDir, cfg, opts)
+			if err != nil {
+				return err
+			}
+			err = os.MkdirAll(outputStylesDir, 0755)
 			if err != nil {
 				return err
 			}
@@ -247,7 +336,11 @@ func buildComponentAssets(cfg *siteconfig.SiteConfig, opts *BuildOptions) error
 		// Copy JS file if it exists
 		jsPath := filepath.Join(componentsDir, componentName+".js")
 		if _, err := os.Stat(jsPath); err == nil {
-			err := os.MkdirAll(outputScriptsDir, 0755)
+			err := confinePath(jsPath, componentsDir, cfg, opts)
+			if err != nil {
+				return err
+			}
+			err = os.MkdirAll(outputScriptsDir, 0755)
 			if err != nil {
 				return err
 			}
@@ -267,6 +360,13 @@ func buildComponentAssets(cfg *siteconfig.SiteConfig, opts *BuildOptions) error
 }
 
 func processDirectory(src, dst string, cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
+	return walkDirectory(src, dst, cfg, opts, make(map[string]bool))
+}
+
+// walkDirectory does the work for processDirectory. active holds the symlinked
+// directories currently being walked so that link loops are reported instead of
+// recursing forever.
+func walkDirectory(src, dst string, cfg *siteconfig.SiteConfig, opts *BuildOptions, active map[string]bool) error {
 	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -279,6 +379,34 @@ func processDirectory(src, dst string, cfg *siteconfig.SiteConfig, opts *BuildOp
 
 		dstPath := filepath.Join(dst, relPath)
 
+		if info.Mode()&os.ModeSymlink != 0 {
+			err := confinePath(path, opts.RootDir, cfg, opts)
+			if err != nil {
+				return err
+			}
+
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return fmt.Errorf("failed to resolve symlink %q: %w", path, err)
+			}
+
+			info, err = os.Stat(target)
+			if err != nil {
+				return err
+			}
+
+			// filepath.Walk does not descend into symlinked directories, so walk the target ourselves
+			if info.IsDir() {
+				if active[target] {
+					return fmt.Errorf("symlink loop detected at %q", path)
+				}
+				active[target] = true
+				defer delete(active, target)
+
+				return walkDirectory(target, dstPath, cfg, opts, active)
+			}
+		}
+
 		if info.IsDir() {
 			err := os.MkdirAll(dstPath, info.Mode())
 			if err != nil {
@@ -295,6 +423,16 @@ func processDirectory(src, dst string, cfg *siteconfig.SiteConfig, opts *BuildOp
 				if err != nil {
 					return err
 				}
+			} else if section, _ := sourceSection(path, cfg, opts); section != "" && strings.HasSuffix(path, ".md") {
+				// Markdown posts and pages are built into the HTML file of the same name
+				htmlPath := strings.TrimSuffix(path, ".md") + ".html"
+				if _, err := os.Stat(htmlPath); err == nil {
+					return fmt.Errorf("both %s and %s exist, keep only one of them", htmlPath, path)
+				}
+				err = processHTMLFile(path, strings.TrimSuffix(dstPath, ".md")+".html", cfg, opts)
+				if err != nil {
+					return err
+				}
 			} else {
 				err = copyFile(path, dstPath, info.Mode())
 				if err != nil {
@@ -307,289 +445,507 @@ func processDirectory(src, dst string, cfg *siteconfig.SiteConfig, opts *BuildOp
 	})
 }
 
+// processHTMLFile builds an HTML file, or a Markdown post or page, into dst.
 func processHTMLFile(src, dst string, cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
-	content, err := os.ReadFile(src)
+	content, err := readPageSource(src)
 	if err != nil {
 		return err
 	}
 
-	// Check if this HTML file is from posts or pages directory
-	absolutePostsDir := filepath.Join(opts.RootDir, cfg.Dirs.Posts)
-	absolutePagesDir := filepath.Join(opts.RootDir, cfg.Dirs.Pages)
+	// Extract the section and page/post identifier for layouts and data-page processing
+	section, currentPageID := sourceSection(src, cfg, opts)
 
-	isFromPostsOrPages := false
-	if relPath, err := filepath.Rel(absolutePostsDir, src); err == nil && !strings.HasPrefix(relPath, "..") {
-		isFromPostsOrPages = true
-	}
-	if !isFromPostsOrPages {
-		if relPath, err := filepath.Rel(absolutePagesDir, src); err == nil && !strings.HasPrefix(relPath, "..") {
-			isFromPostsOrPages = true
+	// A page listing posts is rendered once for every page of posts
+	if section == "pages" {
+		paginated, err := listsPosts(content, src)
+		if err != nil {
+			return err
+		}
+		if paginated {
+			return renderListingPages(content, src, dst, currentPageID, cfg, opts)
 		}
 	}
 
-	// If from posts or pages, merge with base.html
-	if isFromPostsOrPages {
-		baseHTMLPath := filepath.Join(opts.RootDir, cfg.BaseHTML)
-		baseContent, err := os.ReadFile(baseHTMLPath)
-		if err != nil {
-			return fmt.Errorf("failed to read base.html: %w", err)
-		}
+	return renderPage(content, src, dst, section, currentPageID, nil, cfg, opts)
+}
 
-		pageContent := string(content)
-		baseHTML := string(baseContent)
+// sourceSection returns "posts" or "pages" and the post or page folder name if
+// src is in the posts or pages directory, or empty strings otherwise.
+func sourceSection(src string, cfg *siteconfig.SiteConfig, opts *BuildOptions) (string, string) {
+	if relPath, err := filepath.Rel(opts.resolve(cfg.Dirs.Posts), src); err == nil && !strings.HasPrefix(relPath, "..") {
+		return "posts", strings.Split(relPath, string(filepath.Separator))[0]
+	}
+	if relPath, err := filepath.Rel(opts.resolve(cfg.Dirs.Pages), src); err == nil && !strings.HasPrefix(relPath, "..") {
+		return "pages", strings.Split(relPath, string(filepath.Separator))[0]
+	}
+	return "", ""
+}
 
-		// Extract <head> content from page/post (everything between <head> and </head>)
-		headPattern := regexp.MustCompile(`(?i)<head(\s[^>]*)?>([\s\S]*?)</head>`)
-		headMatches := headPattern.FindStringSubmatch(pageContent)
-		var pageHeadContent string
-		if len(headMatches) > 2 {
-			pageHeadContent = headMatches[2]
-		}
+// readPageSource reads a page, rendering it to HTML first if it is written in Markdown.
+func readPageSource(src string) ([]byte, error) {
+	content, err := os.ReadFile(src)
+	if err != nil || filepath.Ext(src) != ".md" {
+		return content, err
+	}
+	return markdownToHTML(content, src)
+}
 
-		// Extract <body> content from page/post (everything between <body> and </body>)
-		bodyPattern := regexp.MustCompile(`(?i)<body(\s[^>]*)?>([\s\S]*?)</body>`)
-		bodyMatches := bodyPattern.FindStringSubmatch(pageContent)
-		var pageBodyContent string
-		if len(bodyMatches) > 2 {
-			pageBodyContent = bodyMatches[2]
-		}
+// renderPage builds a page from its source and writes it to dst. list is the
+// slice of posts shown by @postlist and @pagination, or nil on other pages.
+func renderPage(content []byte, src, dst, section, currentPageID string, list *listing, cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
+	state := newPageState()
+	state.listing = list
+	doc, err := parseHTML(content, src, state)
+	if err != nil {
+		return err
+	}
 
-		// Merge head content into base.html's head
-		if pageHeadContent != "" {
-			baseHeadPattern := regexp.MustCompile(`(?i)(<head(\s[^>]*)?>)([\s\S]*?)(</head>)`)
-			baseHTML = baseHeadPattern.ReplaceAllString(baseHTML, "${1}${3}"+pageHeadContent+"${4}")
+	// Page variables come from the page's own head, before it is merged into a layout
+	outputPath, err := filepath.Rel(opts.resolve(cfg.OutputDir), dst)
+	if err != nil {
+		return err
+	}
+	state.page = readPageMeta(doc, section, currentPageID, outputPath)
+	state.values = maps.Clone(opts.values)
+	state.values["page"] = state.page.values(cfg)
+	if section == "posts" {
+		addPostValues(state, cfg, opts)
+		addSeriesValues(state, opts)
+	}
+	removeMeta(doc, seriesMetaName)
+	removeMeta(doc, seriesPartMetaName)
+	removeMeta(doc, seriesTitleMetaName)
+	removeMeta(doc, expiresMetaName)
+
+	// If from posts or pages, merge into its layout (base.html unless configured otherwise)
+	if section != "" {
+		layoutName := selectLayout(doc, section, currentPageID, cfg)
+		layout, err := loadLayout(layoutName, cfg, opts, state, make(map[string]bool))
+		if err != nil {
+			return err
 		}
 
-		// Merge body content into base.html's body (replace @content placeholder)
-		if pageBodyContent != "" {
-			contentIncludePattern := regexp.MustCompile(`<!--\s*include\s*=\s*"@content"\s*-->`)
-			if !contentIncludePattern.MatchString(baseHTML) {
-				return fmt.Errorf("base.html does not contain @content placeholder")
-			}
-			baseHTML = contentIncludePattern.ReplaceAllString(baseHTML, pageBodyContent)
+		err = mergeIntoLayout(doc, layout, layoutName, state)
+		if err != nil {
+			return err
 		}
 
-		content = []byte(baseHTML)
+		doc = layout
 	}
 
-	// Extract page/post identifier for data-page processing
-	var currentPageID string
-	if isFromPostsOrPages {
-		// Extract the page/post name from the source path
-		if relPath, err := filepath.Rel(absolutePostsDir, src); err == nil && !strings.HasPrefix(relPath, "..") {
-			// It's a post - get the post folder name
-			parts := strings.Split(relPath, string(filepath.Separator))
-			if len(parts) > 0 {
-				currentPageID = parts[0]
-			}
-		} else if relPath, err := filepath.Rel(absolutePagesDir, src); err == nil && !strings.HasPrefix(relPath, "..") {
-			// It's a page - get the page folder name
-			parts := strings.Split(relPath, string(filepath.Separator))
-			if len(parts) > 0 {
-				currentPageID = parts[0]
-			}
-		}
+	if list != nil {
+		addPaginationLinks(doc, list)
+	}
+
+	err = processIncludes(doc, cfg, opts, state)
+	if err != nil {
+		return err
 	}
 
-	visited := make(map[string]bool)
-	componentAssets := make(map[string]bool) // Track component CSS/JS files
-	processed, err := processIncludes(string(content), cfg, opts, visited, filepath.Dir(src), currentPageID, componentAssets)
+	// Give headings ids and fill in <!-- include="@toc" --> once all content is in place
+	processHeadings(doc, cfg)
+
+	// Describe the page to sharing sites unless the author already did
+	addSocialTags(doc, state, filepath.Dir(src), cfg)
+
+	err = addStructuredData(doc, state, filepath.Dir(src), cfg)
 	if err != nil {
 		return err
 	}
 
+	// Mark links to the current page once everything has been included
+	processDataPageAttributes(doc, state, cfg)
+
 	// Inject component CSS and JS files into the HTML
-	processed = injectComponentAssets(processed, componentAssets, cfg, opts)
+	injectComponentAssets(doc, state, cfg, opts)
 
-	err = validateHTML(processed, src)
+	// Add dimensions, responsive variants and lazy loading to images
+	err = processImages(doc, filepath.Dir(src), filepath.Dir(dst), state, cfg, opts)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(dst, []byte(processed), 0644)
-}
+	err = validateHTML(doc, state, src)
+	if err != nil {
+		return err
+	}
 
-func validateHTML(content, filePath string) error {
-	headOpenPattern := regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
-	headClosePattern := regexp.MustCompile(`(?i)</head>`)
-	bodyOpenPattern := regexp.MustCompile(`(?i)<body(\s[^>]*)?>`)
-	bodyClosePattern := regexp.MustCompile(`(?i)</body>`)
+	return os.WriteFile(dst, []byte(renderHTML(doc, state)), 0644)
+}
 
-	hasHeadOpen := headOpenPattern.MatchString(content)
-	hasHeadClose := headClosePattern.MatchString(content)
-	hasBodyOpen := bodyOpenPattern.MatchString(content)
-	hasBodyClose := bodyClosePattern.MatchString(content)
+func validateHTML(doc *html.Node, state *pageState, filePath string) error {
+	head := findElement(doc, "head")
+	body := findElement(doc, "body")
 
-	if hasHeadOpen && !hasHeadClose {
-		slog.Warn("Unclosed <head> tag detected", "file", filePath)
+	if head != nil && !state.nodes[head].closed {
+		slog.Warn("Unclosed <head> tag detected", "file", filePath, "at", state.pos(head))
 	}
 
-	if hasBodyOpen && !hasBodyClose {
-		slog.Warn("Unclosed <body> tag detected", "file", filePath)
+	if body != nil && !state.nodes[body].closed {
+		slog.Warn("Unclosed <body> tag detected", "file", filePath, "at", state.pos(body))
 	}
 
-	if !hasHeadOpen {
+	if head == nil {
 		slog.Warn("Missing <head> tag", "file", filePath)
 	}
 
 	return nil
 }
 
-func processIncludes(content string, cfg *siteconfig.SiteConfig, opts *BuildOptions, visited map[string]bool, currentDir string, currentPageID string, componentAssets map[string]bool) (string, error) {
-	includePattern := regexp.MustCompile(`<!--\s*include\s*=\s*"(@[^"]+)"\s*-->`)
-	var result strings.Builder
-	lastIndex := 0
+var includePattern = regexp.MustCompile(`^\s*include\s*=\s*"(@[^"]+)"\s*$`)
 
-	for _, match := range includePattern.FindAllStringSubmatchIndex(content, -1) {
-		result.WriteString(content[lastIndex:match[0]])
+// processIncludes replaces every <!-- include="@..." --> comment below n with the
+// content it refers to, and every <!-- value="..." --> comment and data-value-*
+// attribute with its value.
+func processIncludes(n *html.Node, cfg *siteconfig.SiteConfig, opts *BuildOptions, state *pageState) error {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
 
-		includePath := content[match[2]:match[3]]
+		if c.Type == html.ElementNode {
+			keep, err := processDataIf(c, state)
+			if err != nil {
+				return err
+			}
+			if !keep {
+				removeNode(c)
+				c = next
+				continue
+			}
 
-		if includePath == "@content" {
-			result.WriteString(content[match[0]:match[1]])
-			lastIndex = match[1]
+			err = expandValueAttributes(c, state)
+			if err != nil {
+				return err
+			}
+		}
+
+		if c.Type != html.CommentNode {
+			err := processIncludes(c, cfg, opts, state)
+			if err != nil {
+				return err
+			}
+			c = next
 			continue
 		}
 
-		if after, ok := strings.CutPrefix(includePath, "@components/"); ok {
-			slog.Debug("Processing component", "component", after)
-			componentName, _ := strings.CutSuffix(after, ".html")
+		if match := valuePattern.FindStringSubmatch(c.Data); match != nil {
+			err := expandValue(c, match[1], match[2] != "", state)
+			if err != nil {
+				return err
+			}
+			c = next
+			continue
+		}
+
+		match := includePattern.FindStringSubmatch(c.Data)
+		if match == nil {
+			c = next
+			continue
+		}
 
-			componentsDir := filepath.Join(opts.RootDir, cfg.Dirs.Components)
-			componentHTMLPath := filepath.Join(componentsDir, componentName+".html")
+		includePath := match[1]
+
+		if includePath == "@content" {
+			c = next
+			continue
+		}
 
-			componentKey := componentHTMLPath
-			if visited[componentKey] {
-				return "", fmt.Errorf("circular include detected: component %q is included multiple times", componentName)
+		if includePath == "@postlist" || includePath == "@pagination" {
+			listed, err := renderListing(includePath, c, cfg, opts, state)
+			if err != nil {
+				return err
 			}
 
-			visited[componentKey] = true
+			replaceWithChildren(c, listed)
+		} else if includePath == "@prevnext" || includePath == "@related" {
+			links, err := renderPostLinks(includePath, c, cfg, opts, state)
+			if err != nil {
+				return err
+			}
 
-			componentContent, err := os.ReadFile(componentHTMLPath)
+			replaceWithChildren(c, links)
+		} else if includePath == "@series" {
+			parts, err := renderSeriesParts(c, cfg, opts, state)
 			if err != nil {
-				delete(visited, componentKey)
-				return "", fmt.Errorf("failed to read component %q: %w", componentName, err)
+				return err
 			}
 
-			// Check for associated CSS and JS files
-			componentCSSPath := filepath.Join(componentsDir, componentName+".css")
-			componentJSPath := filepath.Join(componentsDir, componentName+".js")
+			replaceWithChildren(c, parts)
+		} else if after, ok := strings.CutPrefix(includePath, "@components/"); ok {
+			slog.Debug("Processing component", "component", after)
+			componentName, _ := strings.CutSuffix(after, ".html")
 
-			if _, err := os.Stat(componentCSSPath); err == nil {
-				// CSS file exists, track it for injection
-				componentAssets["css:"+componentName] = true
+			component, err := renderComponent(componentName, c, cfg, opts, state)
+			if err != nil {
+				return err
+			}
+
+			replaceWithChildren(c, component)
+		} else if filePath, ok, err := resolveFileInclude(includePath, cfg, opts); ok {
+			if err != nil {
+				return fmt.Errorf("%s: %w", state.pos(c), err)
 			}
 
-			if _, err := os.Stat(componentJSPath); err == nil {
-				// JS file exists, track it for injection
-				componentAssets["js:"+componentName] = true
+			slog.Debug("Processing file include", "include", includePath)
+			if state.visited[filePath] {
+				return fmt.Errorf("%s: circular include detected: %q is included multiple times", state.pos(c), includePath)
 			}
 
-			processedComponent, err := processIncludes(string(componentContent), cfg, opts, visited, componentsDir, currentPageID, componentAssets)
+			state.visited[filePath] = true
+			inlined, err := inlineFile(filePath, includePath, cfg, opts, state)
+			delete(state.visited, filePath)
 			if err != nil {
-				delete(visited, componentKey)
-				return "", err
+				return fmt.Errorf("%s: %w", state.pos(c), err)
 			}
 
-			// Process data-page attributes in the component
-			processedComponent = processDataPageAttributes(processedComponent, currentPageID)
+			replaceWithChildren(c, inlined)
+		}
 
-			delete(visited, componentKey)
+		c = next
+	}
 
-			result.WriteString(processedComponent)
-			lastIndex = match[1]
-		} else {
-			result.WriteString(content[match[0]:match[1]])
-			lastIndex = match[1]
+	return nil
+}
+
+// renderComponent reads a component, records its CSS and JS for injection and
+// expands its own includes. at is the node including it, used in errors.
+func renderComponent(componentName string, at *html.Node, cfg *siteconfig.SiteConfig, opts *BuildOptions, state *pageState) (*html.Node, error) {
+	componentsDir := opts.resolve(cfg.Dirs.Components)
+	componentHTMLPath := filepath.Join(componentsDir, componentName+".html")
+
+	if err := confinePath(componentHTMLPath, componentsDir, cfg, opts); err != nil {
+		return nil, fmt.Errorf("%s: invalid component %q: %w", state.pos(at), componentName, err)
+	}
+
+	componentKey := componentHTMLPath
+	if state.visited[componentKey] {
+		return nil, fmt.Errorf("%s: circular include detected: component %q is included multiple times", state.pos(at), componentName)
+	}
+
+	state.visited[componentKey] = true
+	defer delete(state.visited, componentKey)
+
+	componentContent, err := os.ReadFile(componentHTMLPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to read component %q: %w", state.pos(at), componentName, err)
+	}
+
+	// Check for associated CSS and JS files
+	componentCSSPath := filepath.Join(componentsDir, componentName+".css")
+	componentJSPath := filepath.Join(componentsDir, componentName+".js")
+
+	if _, err := os.Stat(componentCSSPath); err == nil {
+		// CSS file exists, track it for injection
+		state.componentAssets["css:"+componentName] = true
+	}
+
+	if _, err := os.Stat(componentJSPath); err == nil {
+		// JS file exists, track it for injection
+		state.componentAssets["js:"+componentName] = true
+	}
+
+	component, err := parseHTML(componentContent, componentHTMLPath, state)
+	if err != nil {
+		return nil, err
+	}
+
+	err = processIncludes(component, cfg, opts, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return component, nil
+}
+
+// resolveFileInclude maps an include such as "@assets/icons/github.svg" to the file
+// on disk. The boolean result reports whether the include uses a known alias at all,
+// so unknown includes can be left untouched.
+func resolveFileInclude(includePath string, cfg *siteconfig.SiteConfig, opts *BuildOptions) (string, bool, error) {
+	aliasDirs := map[string]string{
+		"@assets/":  cfg.Dirs.Assets,
+		"@posts/":   cfg.Dirs.Posts,
+		"@pages/":   cfg.Dirs.Pages,
+		"@styles/":  cfg.Dirs.Styles,
+		"@scripts/": cfg.Dirs.Scripts,
+	}
+
+	for alias, dir := range aliasDirs {
+		after, ok := strings.CutPrefix(includePath, alias)
+		if !ok {
+			continue
 		}
+
+		baseDir := opts.resolve(dir)
+		filePath := filepath.Join(baseDir, filepath.FromSlash(after))
+
+		// Refuse anything that escapes the aliased directory, e.g. "@assets/../../secret"
+		if err := confinePath(filePath, baseDir, cfg, opts); err != nil {
+			return "", true, fmt.Errorf("invalid include %q: %w", includePath, err)
+		}
+
+		return filePath, true, nil
 	}
 
-	result.WriteString(content[lastIndex:])
-	return result.String(), nil
+	return "", false, nil
 }
 
-func injectComponentAssets(content string, componentAssets map[string]bool, cfg *siteconfig.SiteConfig, opts *BuildOptions) string {
-	if len(componentAssets) == 0 {
-		return content
+// inlineFile returns the nodes of an included file ready to be placed into HTML.
+// HTML fragments and SVGs are inlined as-is (HTML fragments have their own includes
+// expanded), anything else is treated as text and escaped.
+func inlineFile(filePath, includePath string, cfg *siteconfig.SiteConfig, opts *BuildOptions, state *pageState) (*html.Node, error) {
+	fileContent, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read include %q: %w", includePath, err)
+	}
+
+	if !utf8.Valid(fileContent) {
+		return nil, fmt.Errorf("include %q is not a text file", includePath)
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".html", ".htm":
+		fragment, err := parseHTML(fileContent, filePath, state)
+		if err != nil {
+			return nil, err
+		}
+		err = processIncludes(fragment, cfg, opts, state)
+		if err != nil {
+			return nil, err
+		}
+		return fragment, nil
+	case ".svg":
+		// An XML prolog or doctype is not allowed in the middle of an HTML document
+		svg := xmlPrologPattern.ReplaceAllString(string(fileContent), "")
+		return parseHTML([]byte(strings.TrimSpace(svg)), filePath, state)
+	default:
+		text := &html.Node{Type: html.DocumentNode}
+		text.AppendChild(&html.Node{Type: html.TextNode, Data: html.EscapeString(string(fileContent))})
+		return text, nil
+	}
+}
+
+var xmlPrologPattern = regexp.MustCompile(`(?i)^\s*(<\?xml[^>]*\?>)?\s*(<!DOCTYPE[^>]*>)?`)
+
+// injectComponentAssets adds the stylesheets of included components to the end of
+// <head> and their scripts to the end of <body>.
+func injectComponentAssets(doc *html.Node, state *pageState, cfg *siteconfig.SiteConfig, opts *BuildOptions) {
+	if len(state.componentAssets) == 0 {
+		return
 	}
 
 	// Collect CSS and JS links
-	var cssLinks []string
-	var jsLinks []string
+	var cssLinks []*html.Node
+	var jsLinks []*html.Node
 
-	for asset := range componentAssets {
-		if after, ok := strings.CutPrefix(asset, "css:"); ok {
-			componentName := after
+	for _, asset := range slices.Sorted(maps.Keys(state.componentAssets)) {
+		if componentName, ok := strings.CutPrefix(asset, "css:"); ok {
 			// Use @styles path that will be resolved later
-			cssLinks = append(cssLinks, `<link rel="stylesheet" href="@styles/`+componentName+`.css">`)
-		} else if after0, ok0 := strings.CutPrefix(asset, "js:"); ok0 {
-			componentName := after0
+			cssLinks = append(cssLinks, newElement("link",
+				html.Attribute{Key: "rel", Val: "stylesheet"},
+				html.Attribute{Key: "href", Val: "@styles/" + componentName + ".css"},
+			))
+		} else if componentName, ok := strings.CutPrefix(asset, "js:"); ok {
 			// Use @scripts path that will be resolved later
-			jsLinks = append(jsLinks, `<script src="@scripts/`+componentName+`.js"></script>`)
+			jsLinks = append(jsLinks, newElement("script",
+				html.Attribute{Key: "src", Val: "@scripts/" + componentName + ".js"},
+			))
 		}
 	}
 
-	// Inject CSS into <head> (before </head>)
-	if len(cssLinks) > 0 {
-		headPattern := regexp.MustCompile(`(?i)(</head>)`)
-		cssInjection := "    " + strings.Join(cssLinks, "\n    ") + "\n"
-		content = headPattern.ReplaceAllString(content, cssInjection+"$1")
+	// Inject CSS at the end of <head>
+	if head := findElement(doc, "head"); head != nil {
+		appendIndented(head, cssLinks)
+	}
+
+	// Inject JS at the end of <body>
+	if body := findElement(doc, "body"); body != nil {
+		appendIndented(body, jsLinks)
+	}
+}
+
+// appendIndented appends nodes to the end of an element, one per line.
+func appendIndented(parent *html.Node, nodes []*html.Node) {
+	if len(nodes) == 0 {
+		return
 	}
 
-	// Inject JS before </body>
-	if len(jsLinks) > 0 {
-		bodyPattern := regexp.MustCompile(`(?i)(</body>)`)
-		jsInjection := "    " + strings.Join(jsLinks, "\n    ") + "\n"
-		content = bodyPattern.ReplaceAllString(content, jsInjection+"$1")
+	// Make sure the first node starts on its own line
+	if last := parent.LastChild; last != nil && last.Type == html.TextNode && !strings.HasSuffix(last.Data, "\n") {
+		last.Data += "\n"
 	}
 
-	return content
+	for _, n := range nodes {
+		parent.AppendChild(&html.Node{Type: html.TextNode, Data: "    "})
+		parent.AppendChild(n)
+		parent.AppendChild(&html.Node{Type: html.TextNode, Data: "\n"})
+	}
 }
 
-func processDataPageAttributes(content string, currentPageID string) string {
-	// Pattern to match tags with data-page attribute
-	// Matches: <tag ... data-page="value" ...> or <tag data-page="value" ...>
-	dataPagePattern := regexp.MustCompile(`(<[^>]*\s+)data-page\s*=\s*"([^"]*)"([^>]*>)`)
-
-	return dataPagePattern.ReplaceAllStringFunc(content, func(match string) string {
-		submatch := dataPagePattern.FindStringSubmatch(match)
-		if len(submatch) >= 4 {
-			beforeAttr := submatch[1]
-			dataPageValue := submatch[2]
-			afterAttr := submatch[3]
-
-			// Check if data-page value matches current page ID
-			if currentPageID != "" && dataPageValue == currentPageID {
-				// Check if class attribute already exists in the tag
-				fullTag := beforeAttr + afterAttr
-				classPattern := regexp.MustCompile(`class\s*=\s*"([^"]*)"`)
-				if classMatch := classPattern.FindString(fullTag); classMatch != "" {
-					// Extract existing class value
-					classSubmatch := classPattern.FindStringSubmatch(fullTag)
-					if len(classSubmatch) > 1 {
-						existingClass := classSubmatch[1]
-						if !strings.Contains(existingClass, "active") {
-							newClass := existingClass + " active"
-							// Replace the class attribute
-							result := classPattern.ReplaceAllString(fullTag, `class="`+newClass+`"`)
-							return result
-						}
-						// active already exists, just remove data-page
-						return fullTag
-					}
-				}
-				// No class attribute, add it before the closing >
-				return beforeAttr + `class="active"` + afterAttr
+// processDataPageAttributes removes data-page attributes below n, marking the
+// elements whose data-page matches the current page with the active class and
+// aria-current. data-page takes a space separated list of page names, such as
+// "about", and sections, such as "posts/*". Links inside <nav> without data-page
+// are matched by where their href points instead.
+func processDataPageAttributes(n *html.Node, state *pageState, cfg *siteconfig.SiteConfig) {
+	inNav := make(map[*html.Node]bool)
+	walkElements(n, func(el *html.Node) bool {
+		if el.Data == "nav" || inNav[el.Parent] {
+			inNav[el] = true
+		}
+
+		dataPageValue, ok := getAttr(el, "data-page")
+		if ok {
+			removeAttr(el, "data-page")
+			markActive(el, matchDataPage(dataPageValue, state.page), cfg)
+			return true
+		}
+
+		if el.Data == "a" && inNav[el] {
+			if href, ok := getAttr(el, "href"); ok && hrefURL(href, state.page.Path) == state.page.URL() {
+				markActive(el, "page", cfg)
 			}
-			// Doesn't match or no currentPageID, just remove data-page attribute
-			return beforeAttr + afterAttr
 		}
-		return match
+		return true
 	})
 }
 
+// matchDataPage reports how a data-page value matches the current page, as the
+// value for aria-current: "page" for the page itself, "true" for the section it
+// is in, or an empty string if it does not match.
+func matchDataPage(value string, page pageMeta) string {
+	if page.ID == "" {
+		return ""
+	}
+
+	current := ""
+	for _, pattern := range strings.Fields(value) {
+		section, id, hasSection := strings.Cut(pattern, "/")
+		switch {
+		case !hasSection && pattern == page.ID:
+			return "page"
+		case hasSection && section == page.Section && id == page.ID:
+			return "page"
+		case hasSection && section == page.Section && id == "*":
+			current = "true"
+		}
+	}
+	return current
+}
+
+// markActive adds the active class and aria-current to an element matching the
+// current page. Existing aria-current values are kept.
+func markActive(el *html.Node, current string, cfg *siteconfig.SiteConfig) {
+	if current == "" {
+		return
+	}
+
+	addClass(el, cfg.ActiveClass)
+	if _, ok := getAttr(el, "aria-current"); !ok {
+		setAttr(el, "aria-current", current)
+	}
+}
+
 func resolvePaths(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
-	outputDir := filepath.Join(opts.RootDir, cfg.OutputDir)
+	outputDir := opts.resolve(cfg.OutputDir)
 
 	return filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -727,6 +1083,22 @@ func resolvePaths(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
 			modified = true
 		}
 
+		// Resolve @archive and @series paths (@archive/2025 -> archive/2025/index.html).
+		// These may stand alone, so they are only matched as a whole attribute value
+		// and never inside text such as "me@archive.org".
+		generatedPattern := regexp.MustCompile(`(=\s*["']?)@(archive|series)(/[^"'\s>)]*)?(["'\s>])`)
+		if generatedPattern.MatchString(contentStr) {
+			contentStr = generatedPattern.ReplaceAllStringFunc(contentStr, func(match string) string {
+				submatch := generatedPattern.FindStringSubmatch(match)
+				targetPath := strings.TrimSuffix(submatch[2]+submatch[3], "/")
+				if filepath.Ext(targetPath) == "" {
+					targetPath += "/index.html"
+				}
+				return submatch[1] + calculateRelativePath(targetPath) + submatch[4]
+			})
+			modified = true
+		}
+
 		if modified {
 			return os.WriteFile(path, []byte(contentStr), info.Mode())
 		}
@@ -0,0 +1,444 @@
+package build
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The build works on a tree of html.Node values built straight from the tokenizer
+// rather than with html.Parse. html.Parse applies the full HTML5 tree construction
+// rules (adding missing <head>/<body> tags, moving nodes around) which would make
+// the output differ from what the author wrote. Text nodes keep their raw source
+// so entities and whitespace survive a round trip untouched.
+
+// sourcePos is the file and line a node was parsed from.
+type sourcePos struct {
+	file string
+	line int
+}
+
+func (p sourcePos) String() string {
+	return fmt.Sprintf("%s:%d", p.file, p.line)
+}
+
+// nodeInfo is what the parser knows about a node beyond what html.Node stores.
+type nodeInfo struct {
+	pos sourcePos
+	// raw is the original source of comments and doctypes
+	raw string
+	// closed records whether an element had an explicit end tag
+	closed bool
+	// selfClosing records whether an element was written as <tag />
+	selfClosing bool
+	// bareAttrs lists the attributes written without a value, such as disabled,
+	// so they are not confused with ones written as alt=""
+	bareAttrs []string
+}
+
+// pageState is the state shared while rendering a single page.
+type pageState struct {
+	visited         map[string]bool
+	componentAssets map[string]bool
+	nodes           map[*html.Node]*nodeInfo
+	// page is the metadata of the page being rendered
+	page pageMeta
+	// values are the variables available to value comments
+	values map[string]any
+	// listing is the slice of posts shown on a post listing page
+	listing *listing
+}
+
+func newPageState() *pageState {
+	return &pageState{
+		visited:         make(map[string]bool),
+		componentAssets: make(map[string]bool),
+		nodes:           make(map[*html.Node]*nodeInfo),
+	}
+}
+
+// pos returns the source position of a node, falling back to its closest parsed
+// ancestor for nodes created during the build.
+func (s *pageState) pos(n *html.Node) sourcePos {
+	for ; n != nil; n = n.Parent {
+		if info, ok := s.nodes[n]; ok {
+			return info.pos
+		}
+	}
+	return sourcePos{file: "unknown"}
+}
+
+// voidElements never have children or an end tag.
+var voidElements = []string{"area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source", "track", "wbr"}
+
+// impliedEndTags lists, for a start tag, the open elements it implicitly closes,
+// e.g. a new <li> ends the previous <li>.
+var impliedEndTags = map[string][]string{
+	"body":   {"head"},
+	"li":     {"li"},
+	"dt":     {"dt", "dd"},
+	"dd":     {"dt", "dd"},
+	"tr":     {"tr", "td", "th"},
+	"td":     {"td", "th"},
+	"th":     {"td", "th"},
+	"option": {"option"},
+}
+
+// documentElements are the elements that make up the page skeleton.
+var documentElements = []string{"html", "head", "body"}
+
+// paragraphClosers are the start tags that end an open <p>.
+var paragraphClosers = []string{"address", "article", "aside", "blockquote", "div", "dl", "fieldset", "footer", "form", "h1", "h2", "h3", "h4", "h5", "h6", "header", "hr", "main", "nav", "ol", "p", "pre", "section", "table", "ul"}
+
+// parseHTML builds a node tree from HTML source, recording where every node came
+// from in state so later stages can point at the right file and line.
+func parseHTML(content []byte, file string, state *pageState) (*html.Node, error) {
+	z := html.NewTokenizer(bytes.NewReader(content))
+	root := &html.Node{Type: html.DocumentNode}
+	stack := []*html.Node{root}
+	line := 1
+
+	for {
+		tt := z.Next()
+		raw := string(z.Raw())
+		pos := sourcePos{file: file, line: line}
+		line += strings.Count(raw, "\n")
+		parent := stack[len(stack)-1]
+
+		switch tt {
+		case html.ErrorToken:
+			if errors.Is(z.Err(), io.EOF) {
+				return root, nil
+			}
+			return nil, fmt.Errorf("%s: %w", pos, z.Err())
+
+		case html.TextToken:
+			// Merge with a preceding text node, the tokenizer may split text
+			if last := parent.LastChild; last != nil && last.Type == html.TextNode {
+				last.Data += raw
+				continue
+			}
+			n := &html.Node{Type: html.TextNode, Data: raw}
+			parent.AppendChild(n)
+			state.nodes[n] = &nodeInfo{pos: pos}
+
+		case html.CommentToken, html.DoctypeToken:
+			tok := z.Token()
+			nodeType := html.CommentNode
+			if tt == html.DoctypeToken {
+				nodeType = html.DoctypeNode
+			}
+			n := &html.Node{Type: nodeType, Data: tok.Data}
+			parent.AppendChild(n)
+			state.nodes[n] = &nodeInfo{pos: pos, raw: raw}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+
+			// Close any elements this tag implicitly ends
+			closes := impliedEndTags[tok.Data]
+			if slices.Contains(paragraphClosers, tok.Data) {
+				closes = append(closes, "p")
+			}
+			for len(stack) > 1 && slices.Contains(closes, stack[len(stack)-1].Data) {
+				stack = stack[:len(stack)-1]
+				parent = stack[len(stack)-1]
+			}
+
+			n := &html.Node{Type: html.ElementNode, Data: tok.Data, DataAtom: tok.DataAtom, Attr: tok.Attr}
+			parent.AppendChild(n)
+			info := &nodeInfo{pos: pos, selfClosing: tt == html.SelfClosingTagToken, bareAttrs: bareAttributes(raw)}
+			state.nodes[n] = info
+
+			if tt == html.StartTagToken && !slices.Contains(voidElements, tok.Data) {
+				stack = append(stack, n)
+			}
+
+		case html.EndTagToken:
+			tok := z.Token()
+
+			// Close the nearest matching open element along with anything left open inside
+			// it. </html>, </head> and </body> only count once everything inside them is
+			// closed, so e.g. a </body> shown in a <pre> does not cut off the rest of the page.
+			matched := false
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].Data == tok.Data {
+					if slices.Contains(documentElements, tok.Data) && i < len(stack)-1 {
+						slog.Warn("Ignoring end tag while other elements are still open", "tag", raw, "open", stack[len(stack)-1].Data, "at", pos)
+						break
+					}
+					state.nodes[stack[i]].closed = true
+					stack = stack[:i]
+					matched = true
+					break
+				}
+			}
+
+			// A stray end tag is kept as-is, browsers ignore it anyway
+			if !matched {
+				n := &html.Node{Type: html.TextNode, Data: raw}
+				parent.AppendChild(n)
+				state.nodes[n] = &nodeInfo{pos: pos}
+			}
+		}
+	}
+}
+
+// renderHTML serialises a node tree back into HTML.
+func renderHTML(n *html.Node, state *pageState) string {
+	var b strings.Builder
+	renderNode(&b, n, state)
+	return b.String()
+}
+
+func renderNode(b *strings.Builder, n *html.Node, state *pageState) {
+	info := state.nodes[n]
+
+	switch n.Type {
+	case html.DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			renderNode(b, c, state)
+		}
+	case html.TextNode:
+		b.WriteString(n.Data)
+	case html.CommentNode:
+		if info != nil && info.raw != "" {
+			b.WriteString(info.raw)
+		} else {
+			b.WriteString("<!--" + n.Data + "-->")
+		}
+	case html.DoctypeNode:
+		if info != nil && info.raw != "" {
+			b.WriteString(info.raw)
+		} else {
+			b.WriteString("<!DOCTYPE " + n.Data + ">")
+		}
+	case html.ElementNode:
+		b.WriteString("<" + n.Data)
+		for _, attr := range n.Attr {
+			b.WriteString(" " + attr.Key)
+			if attr.Val != "" || info == nil || !slices.Contains(info.bareAttrs, attr.Key) {
+				b.WriteString(`="` + escapeAttribute(attr.Val) + `"`)
+			}
+		}
+		if info != nil && info.selfClosing {
+			b.WriteString(" />")
+		} else {
+			b.WriteString(">")
+		}
+
+		if slices.Contains(voidElements, n.Data) || (info != nil && info.selfClosing) {
+			return
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			renderNode(b, c, state)
+		}
+
+		// Leave out end tags the author left out, e.g. on a <li> or <p>
+		if info == nil || info.closed {
+			b.WriteString("</" + n.Data + ">")
+		}
+	}
+}
+
+// bareAttributes returns the names of the attributes written without a value in
+// the raw source of a start tag, lower-cased like the tokenizer does.
+func bareAttributes(raw string) []string {
+	var bare []string
+	// Skip "<" and the tag name
+	i := strings.IndexAny(raw, " \t\n\r\f/>")
+	if i < 0 {
+		return nil
+	}
+
+	isSpace := func(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' }
+	for i < len(raw) {
+		for i < len(raw) && (isSpace(raw[i]) || raw[i] == '/') {
+			i++
+		}
+		if i >= len(raw) || raw[i] == '>' {
+			break
+		}
+
+		start := i
+		for i < len(raw) && !isSpace(raw[i]) && raw[i] != '=' && raw[i] != '>' && (raw[i] != '/' || i == start) {
+			i++
+		}
+		name := strings.ToLower(raw[start:i])
+
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+		if i >= len(raw) || raw[i] != '=' {
+			bare = append(bare, name)
+			continue
+		}
+
+		// Skip the value, quoted or not
+		i++
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+		if i < len(raw) && (raw[i] == '"' || raw[i] == '\'') {
+			end := strings.IndexByte(raw[i+1:], raw[i])
+			if end < 0 {
+				break
+			}
+			i += end + 2
+		} else {
+			for i < len(raw) && !isSpace(raw[i]) && raw[i] != '>' {
+				i++
+			}
+		}
+	}
+	return bare
+}
+
+func escapeAttribute(value string) string {
+	value = strings.ReplaceAll(value, "&", "&amp;")
+	return strings.ReplaceAll(value, `"`, "&quot;")
+}
+
+// walkElements calls fn for every element below n in document order. Returning
+// false from fn skips the element's children.
+func walkElements(n *html.Node, fn func(*html.Node) bool) {
+	for c := n.FirstChild; c != nil; {
+		// Grab the next sibling first so fn may move or remove c
+		next := c.NextSibling
+		if c.Type == html.ElementNode {
+			if fn(c) {
+				walkElements(c, fn)
+			}
+		} else if c.FirstChild != nil {
+			walkElements(c, fn)
+		}
+		c = next
+	}
+}
+
+// findElement returns the first element with the given tag name below n.
+func findElement(n *html.Node, tag string) *html.Node {
+	var found *html.Node
+	walkElements(n, func(el *html.Node) bool {
+		if found == nil && el.Data == tag {
+			found = el
+		}
+		return found == nil
+	})
+	return found
+}
+
+// textContent returns the plain text below n. Text nodes hold raw HTML, so
+// entities are decoded.
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				b.WriteString(c.Data)
+			}
+			collect(c)
+		}
+	}
+	collect(n)
+	return html.UnescapeString(b.String())
+}
+
+// getAttr returns the value of an attribute and whether it is present.
+func getAttr(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// attrValue returns the value of an attribute, or an empty string if it is missing.
+func attrValue(n *html.Node, key string) string {
+	value, _ := getAttr(n, key)
+	return value
+}
+
+// setAttr sets an attribute, replacing any existing value.
+func setAttr(n *html.Node, key, value string) {
+	for i, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+// removeAttr removes an attribute if it is present.
+func removeAttr(n *html.Node, key string) {
+	n.Attr = slices.DeleteFunc(n.Attr, func(attr html.Attribute) bool {
+		return attr.Namespace == "" && attr.Key == key
+	})
+}
+
+// addClass adds a class to an element unless it already has it.
+func addClass(n *html.Node, class string) {
+	setAttr(n, "class", mergeClasses(attrValue(n, "class"), class))
+}
+
+// mergeClasses appends the classes in extra that are not already in classes.
+func mergeClasses(classes, extra string) string {
+	fields := strings.Fields(classes)
+	for _, class := range strings.Fields(extra) {
+		if !slices.Contains(fields, class) {
+			fields = append(fields, class)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// newElement creates an element that was not part of any source file.
+func newElement(tag string, attrs ...html.Attribute) *html.Node {
+	return &html.Node{Type: html.ElementNode, Data: tag, DataAtom: atom.Lookup([]byte(tag)), Attr: attrs}
+}
+
+// replaceWithChildren moves every child of from into n's place and removes n.
+func replaceWithChildren(n, from *html.Node) {
+	for c := from.FirstChild; c != nil; {
+		next := c.NextSibling
+		from.RemoveChild(c)
+		n.Parent.InsertBefore(c, n)
+		c = next
+	}
+	n.Parent.RemoveChild(n)
+}
+
+// appendChildren moves every child of from to the end of n.
+func appendChildren(n, from *html.Node) {
+	for c := from.FirstChild; c != nil; {
+		next := c.NextSibling
+		from.RemoveChild(c)
+		n.AppendChild(c)
+		c = next
+	}
+}
+
+// removeNode removes n along with the indentation and line break around it so no
+// blank line is left behind.
+func removeNode(n *html.Node) {
+	if prev := n.PrevSibling; prev != nil && prev.Type == html.TextNode {
+		prev.Data = strings.TrimRight(prev.Data, " \t")
+	}
+	if next := n.NextSibling; next != nil && next.Type == html.TextNode {
+		trimmed := strings.TrimLeft(next.Data, " \t")
+		trimmed = strings.TrimPrefix(trimmed, "\r")
+		next.Data = strings.TrimPrefix(trimmed, "\n")
+	}
+	n.Parent.RemoveChild(n)
+}
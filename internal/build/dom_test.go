@@ -0,0 +1,135 @@
+package build
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// outline describes the element structure below n, e.g. "ul(li li)".
+func outline(n *html.Node) string {
+	var parts []string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if inner := outline(c); inner != "" {
+			parts = append(parts, c.Data+"("+inner+")")
+		} else {
+			parts = append(parts, c.Data)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestParseHTMLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// want is the output when it differs from the input
+		want string
+	}{
+		{"document", "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n    <title>Home</title>\n</head>\n<body>\n    <p>Hello</p>\n</body>\n</html>\n", ""},
+		{"entities", "<p>Fish &amp; chips &lt;3 &nbsp;&#8212;</p>", ""},
+		{"comments", "<!-- include=\"@components/nav.html\" --><p><!--value=\"site.title\"--></p>", ""},
+		{"empty attribute", `<img src="a.png" alt="">`, ""},
+		{"bare attribute", `<input type="checkbox" checked disabled>`, ""},
+		{"single quotes", `<a href='/about/' title="It's">About</a>`, `<a href="/about/" title="It's">About</a>`},
+		{"self closing", `<br /><img src="a.png" alt="" />`, ""},
+		{"omitted end tags", "<ul>\n    <li>One\n    <li>Two\n</ul>\n<p>Three\n<div>Four</div>", ""},
+		{"pre", "<pre><code>if a &lt; b {\n    return\n}</code></pre>", ""},
+		{"textarea", "<textarea>\n<p>not a tag</p> &amp;\n</textarea>", ""},
+		{"script", "<script>if (a < b && c > d) { document.write(\"</p>\") }</script>", ""},
+		{"stray end tag", "<p>One</p></span><p>Two</p>", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := newPageState()
+			doc, err := parseHTML([]byte(test.input), "test.html", state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := test.want
+			if want == "" {
+				want = test.input
+			}
+			if got := renderHTML(doc, state); got != want {
+				t.Errorf("renderHTML() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseHTMLTree(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"nested", "<div><p><a>x</a></p></div>", "div(p(a))"},
+		{"void elements", "<p>a<br>b<img src=\"x.png\">c</p>", "p(br img)"},
+		{"li ends li", "<ul><li>One<li>Two</ul>", "ul(li li)"},
+		{"dd ends dt", "<dl><dt>Term<dd>Definition<dt>Other</dl>", "dl(dt dd dt)"},
+		{"td ends td", "<table><tr><td>a<td>b<tr><td>c</table>", "table(tr(td td) tr(td))"},
+		{"block ends p", "<p>One<div>Two</div>", "p div"},
+		{"p ends p", "<p>One<p>Two", "p p"},
+		{"body ends head", "<html><head><title>x</title><body><p>y</p></body></html>", "html(head(title) body(p))"},
+		{"end tag closes inner elements", "<div><p><span>x</div><p>y</p>", "div(p(span)) p"},
+		{"stray body end tag in pre", "<body><pre><code></body></code></pre><p>After</p></body>", "body(pre(code) p)"},
+		{"stray html end tag in div", "<html><body><div></html></div><p>After</p></body></html>", "html(body(div p))"},
+		{"textarea is raw text", "<textarea><p>x</p></textarea><p>y</p>", "textarea p"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := newPageState()
+			doc, err := parseHTML([]byte(test.input), "test.html", state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := outline(doc); got != test.want {
+				t.Errorf("outline = %q, want %q", got, test.want)
+			}
+			if got := renderHTML(doc, state); got != test.input {
+				t.Errorf("renderHTML() = %q, want %q", got, test.input)
+			}
+		})
+	}
+}
+
+func TestParseHTMLLines(t *testing.T) {
+	state := newPageState()
+	doc, err := parseHTML([]byte("<div>\n<p>\none\n</p>\n\n<img src=\"x.png\">\n</div>"), "test.html", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for tag, want := range map[string]int{"div": 1, "p": 2, "img": 6} {
+		if got := state.pos(findElement(doc, tag)).line; got != want {
+			t.Errorf("<%s> line = %d, want %d", tag, got, want)
+		}
+	}
+}
+
+func TestBareAttributes(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{`<img src="a.png" alt="">`, nil},
+		{`<input checked>`, []string{"checked"}},
+		{`<input type=checkbox CHECKED disabled/>`, []string{"checked", "disabled"}},
+		{"<option\n  selected\n  value = 'x'>", []string{"selected"}},
+		{`<a title="a > b" hidden>`, []string{"hidden"}},
+		{`<br/>`, nil},
+	}
+
+	for _, test := range tests {
+		got := bareAttributes(test.raw)
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("bareAttributes(%q) = %q, want %q", test.raw, got, test.want)
+		}
+	}
+}
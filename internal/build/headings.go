@@ -0,0 +1,214 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"velcro/internal/siteconfig"
+
+	"golang.org/x/net/html"
+)
+
+// headingAnchorClass is the class of the self-link added to headings when
+// headings.anchors is enabled.
+const headingAnchorClass = "heading-anchor"
+
+// tocSkippedTags hold headings that are not part of the page's own content.
+var tocSkippedTags = map[string]bool{
+	"nav":    true,
+	"header": true,
+	"footer": true,
+	"aside":  true,
+}
+
+// heading is an h2-h4 element of the page along with its text.
+type heading struct {
+	node  *html.Node
+	level int
+	text  string
+}
+
+// processHeadings gives every h2-h4 a stable id, adds self-links if configured
+// and replaces <!-- include="@toc" --> markers with a list of the headings.
+func processHeadings(doc *html.Node, cfg *siteconfig.SiteConfig) {
+	// Ids the author wrote are kept and never reused
+	used := make(map[string]bool)
+	walkElements(doc, func(el *html.Node) bool {
+		if id, ok := getAttr(el, "id"); ok {
+			used[id] = true
+		}
+		return true
+	})
+
+	var headings []heading
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				collect(c)
+				continue
+			}
+			if tocSkippedTags[c.Data] {
+				continue
+			}
+			if level := headingLevel(c); level >= 2 && level <= 4 {
+				headings = append(headings, heading{node: c, level: level, text: headingText(c)})
+				continue
+			}
+			collect(c)
+		}
+	}
+	collect(doc)
+
+	for _, h := range headings {
+		id, ok := getAttr(h.node, "id")
+		if !ok {
+			id = uniqueSlug(Slugify(h.text), used)
+			setAttr(h.node, "id", id)
+		}
+
+		if cfg.Headings.Anchors {
+			anchor := newElement("a",
+				html.Attribute{Key: "class", Val: headingAnchorClass},
+				html.Attribute{Key: "href", Val: "#" + id},
+				html.Attribute{Key: "aria-label", Val: "Link to this section"},
+			)
+			anchor.AppendChild(&html.Node{Type: html.TextNode, Data: "#"})
+			h.node.AppendChild(&html.Node{Type: html.TextNode, Data: " "})
+			h.node.AppendChild(anchor)
+		}
+	}
+
+	for _, marker := range findIncludes(doc, "@toc") {
+		if len(headings) == 0 {
+			removeNode(marker)
+			continue
+		}
+		marker.Parent.InsertBefore(renderTOC(headings), marker)
+		marker.Parent.RemoveChild(marker)
+	}
+}
+
+// headingLevel returns 1-6 for h1-h6 elements and 0 for anything else.
+func headingLevel(n *html.Node) int {
+	if len(n.Data) == 2 && n.Data[0] == 'h' && n.Data[1] >= '1' && n.Data[1] <= '6' {
+		return int(n.Data[1] - '0')
+	}
+	return 0
+}
+
+// headingText returns the text of a heading on one line, without its self-link.
+func headingText(n *html.Node) string {
+	var b strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode:
+				b.WriteString(c.Data)
+			case c.Type == html.ElementNode && c.Data == "a" && attrValue(c, "class") == headingAnchorClass:
+			default:
+				collect(c)
+			}
+		}
+	}
+	collect(n)
+	return strings.Join(strings.Fields(html.UnescapeString(b.String())), " ")
+}
+
+// renderTOC builds a nested list of links to the headings. A heading is nested
+// below the closest earlier heading of a higher level.
+func renderTOC(headings []heading) *html.Node {
+	nav := newElement("nav", html.Attribute{Key: "class", Val: "toc"}, html.Attribute{Key: "aria-label", Val: "Table of contents"})
+
+	type level struct {
+		level int
+		list  *html.Node
+	}
+	root := newElement("ul")
+	nav.AppendChild(root)
+	stack := []level{{level: 0, list: root}}
+
+	for _, h := range headings {
+		for len(stack) > 1 && stack[len(stack)-1].level >= h.level {
+			stack = stack[:len(stack)-1]
+		}
+
+		parent := stack[len(stack)-1].list
+
+		item := newElement("li")
+		link := newElement("a", html.Attribute{Key: "href", Val: "#" + attrValue(h.node, "id")})
+		link.AppendChild(&html.Node{Type: html.TextNode, Data: html.EscapeString(h.text)})
+		item.AppendChild(link)
+		parent.AppendChild(item)
+
+		sublist := newElement("ul")
+		item.AppendChild(sublist)
+		stack = append(stack, level{level: h.level, list: sublist})
+	}
+
+	// Drop the lists that ended up without items
+	walkElements(nav, func(el *html.Node) bool {
+		if el.Data == "ul" && el.FirstChild == nil {
+			el.Parent.RemoveChild(el)
+			return false
+		}
+		return true
+	})
+
+	return nav
+}
+
+// Slugify turns text such as "Why Velcro?" into "why-velcro", keeping letters and
+// digits of any script. It names heading ids, series folders and new posts.
+func Slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		case r == '\'' || r == '’':
+			// Apostrophes are dropped so "It's" becomes "its" rather than "it-s"
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// uniqueSlug returns slug, or slug-2, slug-3... if it is already used.
+func uniqueSlug(slug string, used map[string]bool) string {
+	if slug == "" {
+		slug = "section"
+	}
+
+	id := slug
+	for i := 2; used[id]; i++ {
+		id = fmt.Sprintf("%s-%d", slug, i)
+	}
+	used[id] = true
+	return id
+}
+
+// findIncludes returns every <!-- include="..." --> comment for includePath below n.
+func findIncludes(n *html.Node, includePath string) []*html.Node {
+	var found []*html.Node
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.CommentNode {
+				if match := includePattern.FindStringSubmatch(c.Data); match != nil && match[1] == includePath {
+					found = append(found, c)
+				}
+			}
+			find(c)
+		}
+	}
+	find(n)
+	return found
+}
@@ -0,0 +1,235 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"velcro/internal/siteconfig"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/net/html"
+)
+
+// processImages updates the <img> elements below doc so that local PNG and JPEG
+// images get width/height attributes, resized srcset variants and lazy loading.
+// srcDir and dstDir are the directories of the source and output HTML files and
+// are used to resolve post-local images.
+func processImages(doc *html.Node, srcDir, dstDir string, state *pageState, cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
+	imageIndex := 0
+	var firstErr error
+
+	walkElements(doc, func(img *html.Node) bool {
+		if firstErr != nil || img.Data != "img" {
+			return firstErr == nil
+		}
+
+		// Images above the fold should load straight away
+		if _, ok := getAttr(img, "loading"); !ok && imageIndex >= cfg.Images.Eager {
+			setAttr(img, "loading", "lazy")
+		}
+		imageIndex++
+
+		src := attrValue(img, "src")
+		sourcePath, outputDir, srcPrefix, ok := resolveImage(src, srcDir, dstDir, cfg, opts)
+		if !ok {
+			return false
+		}
+
+		if err := confinePath(sourcePath, opts.RootDir, cfg, opts); err != nil {
+			firstErr = fmt.Errorf("%s: invalid image %q: %w", state.pos(img), src, err)
+			return false
+		}
+
+		// A broken image should not stop the whole site from building
+		f, err := os.Open(sourcePath)
+		if err != nil {
+			slog.Warn("Failed to open image, leaving it as is", "image", src, "at", state.pos(img), "error", err)
+			return false
+		}
+		imgConfig, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			slog.Warn("Failed to decode image, leaving it as is", "image", src, "at", state.pos(img), "error", err)
+			return false
+		}
+
+		var variants []imageVariant
+		if _, ok := getAttr(img, "srcset"); !ok && len(cfg.Images.Widths) > 0 {
+			variants, err = generateImageVariants(sourcePath, outputDir, imgConfig.Width, cfg, opts)
+			if errors.Is(err, errImageDecode) {
+				slog.Warn("Failed to decode image, leaving it as is", "image", src, "at", state.pos(img), "error", err)
+				return false
+			}
+			if err != nil {
+				firstErr = fmt.Errorf("%s: failed to resize image %q: %w", state.pos(img), src, err)
+				return false
+			}
+		}
+
+		// Explicit dimensions prevent layout shift while the image loads
+		_, hasWidth := getAttr(img, "width")
+		_, hasHeight := getAttr(img, "height")
+		if !hasWidth && !hasHeight {
+			setAttr(img, "width", strconv.Itoa(imgConfig.Width))
+			setAttr(img, "height", strconv.Itoa(imgConfig.Height))
+		}
+
+		if len(variants) == 0 {
+			return false
+		}
+
+		var srcset []string
+		for _, v := range variants {
+			srcset = append(srcset, fmt.Sprintf("%s%s %dw", srcPrefix, v.name, v.width))
+		}
+		srcset = append(srcset, fmt.Sprintf("%s %dw", src, imgConfig.Width))
+		setAttr(img, "srcset", strings.Join(srcset, ", "))
+
+		if _, ok := getAttr(img, "sizes"); !ok && cfg.Images.Sizes != "" {
+			setAttr(img, "sizes", cfg.Images.Sizes)
+		}
+
+		return false
+	})
+
+	return firstErr
+}
+
+// resolveImage maps an <img> src to the source file on disk, the output directory
+// its variants are written to and the src prefix used to reference those variants.
+// Only @assets paths and post-local relative paths to PNG/JPEG files are handled.
+func resolveImage(src, srcDir, dstDir string, cfg *siteconfig.SiteConfig, opts *BuildOptions) (string, string, string, bool) {
+	ext := strings.ToLower(path.Ext(src))
+	if ext != ".png" && ext != ".jpg" && ext != ".jpeg" {
+		return "", "", "", false
+	}
+
+	if after, ok := strings.CutPrefix(src, "@assets/"); ok {
+		sourcePath := filepath.Join(opts.resolve(cfg.Dirs.Assets), filepath.FromSlash(after))
+		outputDir := filepath.Join(opts.resolve(cfg.OutputDir), "assets", filepath.FromSlash(path.Dir(after)))
+		srcPrefix := "@assets/" + dirPrefix(after)
+		return sourcePath, outputDir, srcPrefix, true
+	}
+
+	// Anything else with a scheme, alias or absolute path is not ours to touch
+	if strings.HasPrefix(src, "@") || strings.HasPrefix(src, "/") || strings.Contains(src, ":") {
+		return "", "", "", false
+	}
+
+	sourcePath := filepath.Join(srcDir, filepath.FromSlash(src))
+	outputDir := filepath.Join(dstDir, filepath.FromSlash(path.Dir(src)))
+	return sourcePath, outputDir, dirPrefix(src), true
+}
+
+// dirPrefix returns the directory part of a slash separated path including the
+// trailing slash, or an empty string if the path has no directory.
+func dirPrefix(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+// errImageDecode is returned for images that cannot be read as PNG or JPEG.
+var errImageDecode = errors.New("failed to decode image")
+
+type imageVariant struct {
+	name  string
+	width int
+}
+
+// generateImageVariants writes a resized copy of the image for every configured
+// width smaller than the original. Variants are encoded once per build, so images
+// used on several pages are only resized once and changes to [images] always
+// apply.
+func generateImageVariants(sourcePath, outputDir string, originalWidth int, cfg *siteconfig.SiteConfig, opts *BuildOptions) ([]imageVariant, error) {
+	ext := filepath.Ext(sourcePath)
+	baseName := strings.TrimSuffix(filepath.Base(sourcePath), ext)
+
+	var variants []imageVariant
+	var decoded image.Image
+
+	for _, width := range cfg.Images.Widths {
+		if width <= 0 || width >= originalWidth {
+			continue
+		}
+
+		name := fmt.Sprintf("%s-%dw%s", baseName, width, ext)
+		variants = append(variants, imageVariant{name: name, width: width})
+
+		dstPath := filepath.Join(outputDir, name)
+		if opts.imageVariants[dstPath] {
+			continue
+		}
+
+		if decoded == nil {
+			var err error
+			decoded, err = decodeImage(sourcePath)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", errImageDecode, err)
+			}
+		}
+
+		slog.Debug("Resizing image", "image", sourcePath, "width", width)
+		err := writeResizedImage(decoded, dstPath, width, cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.imageVariants[dstPath] = true
+	}
+
+	return variants, nil
+}
+
+func decodeImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+func writeResizedImage(img image.Image, dstPath string, width int, cfg *siteconfig.SiteConfig) error {
+	bounds := img.Bounds()
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+
+	err := os.MkdirAll(filepath.Dir(dstPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	dstFile, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	switch strings.ToLower(filepath.Ext(dstPath)) {
+	case ".png":
+		return png.Encode(dstFile, resized)
+	default:
+		quality := cfg.Images.Quality
+		if quality <= 0 || quality > 100 {
+			quality = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(dstFile, resized, &jpeg.Options{Quality: quality})
+	}
+}
@@ -0,0 +1,215 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"velcro/internal/siteconfig"
+
+	"golang.org/x/net/html"
+)
+
+// layoutMetaName is the meta tag a page or layout uses to pick the layout it is
+// merged into, e.g. <meta name="velcro:layout" content="wide">.
+const layoutMetaName = "velcro:layout"
+
+// selectLayout picks the layout for a page. A velcro:layout meta tag wins, then a
+// [layouts] entry for the page/post folder name, then one for its section. An
+// empty name means the global base_html.
+func selectLayout(doc *html.Node, section, pageID string, cfg *siteconfig.SiteConfig) string {
+	if name, ok := findMeta(doc, layoutMetaName); ok {
+		return name
+	}
+
+	if _, ok := cfg.Layouts[pageID]; ok {
+		return pageID
+	}
+
+	if _, ok := cfg.Layouts[section]; ok {
+		return section
+	}
+
+	return ""
+}
+
+// loadLayout reads a layout and, if it names a parent layout of its own, merges it
+// into that parent so the result is a complete document with a single @content
+// placeholder left for the page.
+func loadLayout(name string, cfg *siteconfig.SiteConfig, opts *BuildOptions, state *pageState, visited map[string]bool) (*html.Node, error) {
+	layoutPath := cfg.BaseHTML
+	if name != "" {
+		path, ok := cfg.Layouts[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown layout %q", name)
+		}
+		layoutPath = path
+	}
+
+	if visited[name] {
+		return nil, fmt.Errorf("circular layout detected: layout %q is its own parent", name)
+	}
+	visited[name] = true
+
+	absoluteLayoutPath := opts.resolve(layoutPath)
+	layoutContent, err := os.ReadFile(absoluteLayoutPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layout %q: %w", layoutPath, err)
+	}
+
+	layout, err := parseHTML(layoutContent, absoluteLayoutPath, state)
+	if err != nil {
+		return nil, err
+	}
+
+	parentName, ok := findMeta(layout, layoutMetaName)
+	if !ok {
+		return layout, nil
+	}
+
+	parent, err := loadLayout(parentName, cfg, opts, state, visited)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mergeIntoLayout(layout, parent, parentName, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return parent, nil
+}
+
+// mergeIntoLayout moves the page's <head> content to the end of the layout's head
+// and puts the page's <body> content in place of the layout's @content placeholder.
+func mergeIntoLayout(page, layout *html.Node, layoutName string, state *pageState) error {
+	if layoutName == "" {
+		layoutName = "base.html"
+	}
+
+	removeMeta(page, layoutMetaName)
+
+	pageHead := findElement(page, "head")
+	pageBody := findElement(page, "body")
+	layoutHead := findElement(layout, "head")
+
+	// Page head tags replace layout head tags with the same identity (e.g. <title>)
+	if pageHead != nil && layoutHead != nil {
+		pageHeadIdentities := make(map[string]bool)
+		for c := pageHead.FirstChild; c != nil; c = c.NextSibling {
+			if identity := headTagIdentity(c); identity != "" {
+				pageHeadIdentities[identity] = true
+			}
+		}
+
+		for c := layoutHead.FirstChild; c != nil; {
+			next := c.NextSibling
+			if pageHeadIdentities[headTagIdentity(c)] {
+				removeNode(c)
+			}
+			c = next
+		}
+	}
+
+	// Merge <html> and <body> attributes, e.g. <html lang="fr"> or <body class="post">
+	for _, tagName := range []string{"html", "body"} {
+		pageTag := findElement(page, tagName)
+		layoutTag := findElement(layout, tagName)
+		if pageTag != nil && layoutTag != nil {
+			mergeAttributes(layoutTag, pageTag)
+		}
+	}
+
+	// Merge head content into the layout's head
+	if pageHead != nil && layoutHead != nil {
+		appendChildren(layoutHead, pageHead)
+	}
+
+	// Merge body content into the layout's body (replace @content placeholder)
+	if pageBody != nil && pageBody.FirstChild != nil {
+		placeholder := findContentPlaceholder(layout)
+		if placeholder == nil {
+			return fmt.Errorf("layout %q does not contain @content placeholder", layoutName)
+		}
+		replaceWithChildren(placeholder, pageBody)
+	}
+
+	return nil
+}
+
+// findContentPlaceholder returns the <!-- include="@content" --> comment below n.
+func findContentPlaceholder(n *html.Node) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.CommentNode {
+			if match := includePattern.FindStringSubmatch(c.Data); match != nil && match[1] == "@content" {
+				return c
+			}
+		}
+		if found := findContentPlaceholder(c); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findMeta returns the content of the first <meta name="..."> tag with the given name.
+func findMeta(n *html.Node, name string) (string, bool) {
+	var content string
+	var found bool
+	walkElements(n, func(el *html.Node) bool {
+		if !found && el.Data == "meta" && strings.EqualFold(attrValue(el, "name"), name) {
+			content, found = attrValue(el, "content"), true
+		}
+		return !found
+	})
+	return content, found
+}
+
+// removeMeta strips every <meta name="..."> tag with the given name.
+func removeMeta(n *html.Node, name string) {
+	walkElements(n, func(el *html.Node) bool {
+		if el.Data == "meta" && strings.EqualFold(attrValue(el, "name"), name) {
+			removeNode(el)
+		}
+		return true
+	})
+}
+
+// headTagIdentity returns the key under which a page head tag overrides a layout
+// head tag, or an empty string if the tag can appear any number of times.
+func headTagIdentity(n *html.Node) string {
+	if n.Type != html.ElementNode {
+		return ""
+	}
+
+	switch n.Data {
+	case "title":
+		return "title"
+	case "meta":
+		if name := attrValue(n, "name"); name != "" {
+			return "meta:name:" + strings.ToLower(name)
+		}
+		if property := attrValue(n, "property"); property != "" {
+			return "meta:property:" + strings.ToLower(property)
+		}
+		if _, ok := getAttr(n, "charset"); ok {
+			return "meta:charset"
+		}
+	case "link":
+		if strings.EqualFold(attrValue(n, "rel"), "canonical") {
+			return "link:canonical"
+		}
+	}
+	return ""
+}
+
+// mergeAttributes copies the attributes of a page element onto the same element in
+// the layout. Page values win, except for class where both are kept.
+func mergeAttributes(layoutTag, pageTag *html.Node) {
+	for _, attr := range pageTag.Attr {
+		if attr.Key == "class" {
+			addClass(layoutTag, attr.Val)
+			continue
+		}
+		setAttr(layoutTag, attr.Key, attr.Val)
+	}
+}
@@ -0,0 +1,256 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"velcro/internal/siteconfig"
+
+	"golang.org/x/net/html"
+)
+
+// defaultPostItem renders each post of a @postlist and defaultPagination the links
+// between its pages. components/post-item.html and components/pagination.html
+// replace them.
+const (
+	defaultPostItem = `<article class="post-item">
+    <h2><a data-value-href="post.href"><!-- value="post.title" --></a></h2>
+    <time data-if="post.date" data-value-datetime="post.date"><!-- value="post.date" --></time>
+    <span data-if="post.reading_time"><!-- value="post.reading_time" --> min read</span>
+    <p data-if="post.excerpt"><!-- value="post.excerpt" --></p>
+</article>
+`
+	defaultPagination = `<nav class="pagination" aria-label="Pagination">
+    <a data-if="pagination.prev" data-value-href="pagination.prev" rel="prev">Newer posts</a>
+    <span>Page <!-- value="pagination.page" --> of <!-- value="pagination.pages" --></span>
+    <a data-if="pagination.next" data-value-href="pagination.next" rel="next">Older posts</a>
+</nav>
+`
+)
+
+// listing is the slice of posts shown on one page of a post listing.
+type listing struct {
+	posts []pageMeta
+	// page is the 1-based number of this page out of pages
+	page, pages int
+	// hrefs links to every page of the listing, hrefs[0] being the first page
+	hrefs []string
+}
+
+// prev returns the link to the previous page, or an empty string on the first page.
+func (l *listing) prev() string {
+	if l.page <= 1 {
+		return ""
+	}
+	return l.hrefs[l.page-2]
+}
+
+// next returns the link to the next page, or an empty string on the last page.
+func (l *listing) next() string {
+	if l.page >= l.pages {
+		return ""
+	}
+	return l.hrefs[l.page]
+}
+
+func (l *listing) values() map[string]any {
+	return map[string]any{
+		"page":  l.page,
+		"pages": l.pages,
+		"prev":  l.prev(),
+		"next":  l.next(),
+		"first": l.hrefs[0],
+		"last":  l.hrefs[len(l.hrefs)-1],
+	}
+}
+
+// collectPosts reads the metadata of every post, newest first. Posts without a
+// valid date are listed last.
+func collectPosts(cfg *siteconfig.SiteConfig, opts *BuildOptions) ([]pageMeta, error) {
+	postsDir := opts.resolve(cfg.Dirs.Posts)
+	entries, err := os.ReadDir(postsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var posts []pageMeta
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		// A post is written either in HTML or in Markdown
+		src := filepath.Join(postsDir, entry.Name(), "index.html")
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			src = filepath.Join(postsDir, entry.Name(), "index.md")
+		}
+		content, err := readPageSource(src)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		doc, err := parseHTML(content, src, newPageState())
+		if err != nil {
+			return nil, err
+		}
+
+		outputPath := path.Join("posts", entry.Name(), "index.html")
+		posts = append(posts, readPageMeta(doc, "posts", entry.Name(), outputPath))
+	}
+
+	slices.SortStableFunc(posts, func(a, b pageMeta) int {
+		if c := b.Time.Compare(a.Time); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return posts, nil
+}
+
+// listsPosts reports whether a page has an <!-- include="@postlist" --> comment.
+// Only the page itself is checked, as whether a page is paginated has to be known
+// before it is rendered.
+func listsPosts(content []byte, src string) (bool, error) {
+	// Most pages do not mention @postlist at all and need no parsing
+	if !bytes.Contains(content, []byte("@postlist")) {
+		return false, nil
+	}
+
+	doc, err := parseHTML(content, src, newPageState())
+	if err != nil {
+		return false, err
+	}
+	return len(findIncludes(doc, "@postlist")) > 0, nil
+}
+
+// renderListingPages renders a page that lists posts once for every page_size
+// posts. The first page is written to dst and the others to page/<n>/ next to it.
+func renderListingPages(content []byte, src, dst, pageID string, cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
+	posts := opts.posts
+	pageSize := cfg.Pagination.PageSize
+	pages := max(1, (len(posts)+pageSize-1)/pageSize)
+
+	outputDir := opts.resolve(cfg.OutputDir)
+	dsts := make([]string, pages)
+	hrefs := make([]string, pages)
+	for i := range pages {
+		dsts[i] = dst
+		if i > 0 {
+			dsts[i] = filepath.Join(filepath.Dir(dst), "page", strconv.Itoa(i+1), "index.html")
+		}
+
+		outputPath, err := filepath.Rel(outputDir, dsts[i])
+		if err != nil {
+			return err
+		}
+		hrefs[i] = pageMeta{Section: "pages", ID: pageID, Path: filepath.ToSlash(outputPath)}.Href()
+	}
+
+	// Remove the pages of an earlier build that had more posts to list
+	pageDir := filepath.Join(filepath.Dir(dst), "page")
+	entries, err := os.ReadDir(pageDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if n, err := strconv.Atoi(entry.Name()); err == nil && n > pages {
+			err := os.RemoveAll(filepath.Join(pageDir, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := range pages {
+		list := &listing{
+			posts: posts[i*pageSize : min((i+1)*pageSize, len(posts))],
+			page:  i + 1,
+			pages: pages,
+			hrefs: hrefs,
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dsts[i]), 0755); err != nil {
+			return err
+		}
+
+		err := renderPage(content, src, dsts[i], "pages", pageID, list, cfg, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renderListing renders the @postlist or @pagination include of a listing page.
+func renderListing(includePath string, at *html.Node, cfg *siteconfig.SiteConfig, opts *BuildOptions, state *pageState) (*html.Node, error) {
+	if state.listing == nil {
+		return nil, fmt.Errorf("%s: %s can only be used in a page that has <!-- include=\"@postlist\" --> written in it", state.pos(at), includePath)
+	}
+
+	if includePath == "@pagination" {
+		state.values["pagination"] = state.listing.values()
+		defer delete(state.values, "pagination")
+		return renderUserComponent("pagination", defaultPagination, at, cfg, opts, state)
+	}
+
+	list := &html.Node{Type: html.DocumentNode}
+	for _, post := range state.listing.posts {
+		state.values["post"] = post.values(cfg)
+		item, err := renderUserComponent("post-item", defaultPostItem, at, cfg, opts, state)
+		delete(state.values, "post")
+		if err != nil {
+			return nil, err
+		}
+
+		appendChildren(list, item)
+	}
+	return list, nil
+}
+
+// renderUserComponent renders components/<name>.html if the project has one, or
+// the built-in fallback markup otherwise.
+func renderUserComponent(name, fallback string, at *html.Node, cfg *siteconfig.SiteConfig, opts *BuildOptions, state *pageState) (*html.Node, error) {
+	componentPath := filepath.Join(opts.resolve(cfg.Dirs.Components), name+".html")
+	if _, err := os.Stat(componentPath); err == nil {
+		return renderComponent(name, at, cfg, opts, state)
+	}
+
+	fragment, err := parseHTML([]byte(fallback), "built-in "+name, state)
+	if err != nil {
+		return nil, err
+	}
+
+	err = processIncludes(fragment, cfg, opts, state)
+	if err != nil {
+		return nil, err
+	}
+	return fragment, nil
+}
+
+// addPaginationLinks adds <link rel="prev"> and <link rel="next"> to the head of
+// a listing page so crawlers can follow the pages.
+func addPaginationLinks(doc *html.Node, list *listing) {
+	head := findElement(doc, "head")
+	if head == nil {
+		return
+	}
+
+	var links []*html.Node
+	if prev := list.prev(); prev != "" {
+		links = append(links, newElement("link", html.Attribute{Key: "rel", Val: "prev"}, html.Attribute{Key: "href", Val: prev}))
+	}
+	if next := list.next(); next != "" {
+		links = append(links, newElement("link", html.Attribute{Key: "rel", Val: "next"}, html.Attribute{Key: "href", Val: next}))
+	}
+	appendIndented(head, links)
+}
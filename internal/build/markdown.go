@@ -0,0 +1,42 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// markdown renders .md posts and pages. Raw HTML is kept so that includes, values
+// and other markup can be mixed into the Markdown.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(html.WithUnsafe()),
+)
+
+// markdownToHTML turns a Markdown source into an HTML page. The source may start
+// with a <head> block holding the title and meta tags, which is kept as it is;
+// everything after it is rendered into the <body>.
+func markdownToHTML(content []byte, src string) ([]byte, error) {
+	var head []byte
+	body := content
+	if trimmed := bytes.TrimSpace(content); bytes.HasPrefix(trimmed, []byte("<head>")) {
+		end := bytes.Index(trimmed, []byte("</head>"))
+		if end < 0 {
+			return nil, fmt.Errorf("%s: <head> is not closed", src)
+		}
+		end += len("</head>")
+		head, body = trimmed[:end], trimmed[end:]
+	}
+
+	var out bytes.Buffer
+	out.Write(head)
+	out.WriteString("\n\n<body>\n")
+	if err := markdown.Convert(body, &out); err != nil {
+		return nil, fmt.Errorf("%s: failed to render Markdown: %w", src, err)
+	}
+	out.WriteString("</body>\n")
+	return out.Bytes(), nil
+}
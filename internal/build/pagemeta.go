@@ -0,0 +1,183 @@
+package build
+
+import (
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"velcro/internal/siteconfig"
+
+	"golang.org/x/net/html"
+)
+
+// pageMeta is what a page says about itself in its own <head>, along with where
+// it ends up in the built site.
+type pageMeta struct {
+	ID          string
+	Section     string
+	Title       string
+	Description string
+	Date        string
+	// Time is Date parsed as YYYY-MM-DD, or the zero time if it is missing or invalid
+	Time time.Time
+	// Path is the output file relative to the output directory, using slashes
+	Path string
+	// Tags are the comma separated <meta name="keywords"> of the page
+	Tags []string
+	// Series is the velcro:series the post is part of, SeriesTitle its optional
+	// velcro:series-title and SeriesPart its velcro:series-part, or 0 if unset
+	Series      string
+	SeriesTitle string
+	SeriesPart  int
+	// Expires is the velcro:expires date or time, after which the post is unpublished
+	Expires string
+	// Words, ReadingTime (in minutes) and Excerpt describe the page's own content
+	Words       int
+	ReadingTime int
+	Excerpt     string
+}
+
+// readPageMeta reads the metadata of a page before it is merged into its layout,
+// so only the page's own tags are seen.
+func readPageMeta(doc *html.Node, section, id, outputPath string) pageMeta {
+	meta := pageMeta{
+		ID:      id,
+		Section: section,
+		Path:    filepath.ToSlash(outputPath),
+	}
+
+	if head := findElement(doc, "head"); head != nil {
+		if title := findElement(head, "title"); title != nil {
+			meta.Title = strings.TrimSpace(textContent(title))
+		}
+		meta.Description, _ = findMeta(head, "description")
+		meta.Date, _ = findMeta(head, "date")
+
+		meta.Expires, _ = findMeta(head, expiresMetaName)
+		meta.Series, _ = findMeta(head, seriesMetaName)
+		meta.Series = strings.TrimSpace(meta.Series)
+		meta.SeriesTitle, _ = findMeta(head, seriesTitleMetaName)
+		if part, ok := findMeta(head, seriesPartMetaName); ok {
+			meta.SeriesPart, _ = strconv.Atoi(strings.TrimSpace(part))
+		}
+
+		if keywords, ok := findMeta(head, "keywords"); ok {
+			for _, tag := range strings.Split(keywords, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					meta.Tags = append(meta.Tags, tag)
+				}
+			}
+		}
+	}
+
+	meta.Words, meta.ReadingTime, meta.Excerpt = readContentStats(doc)
+
+	if meta.Date != "" {
+		if t, err := time.Parse(time.DateOnly, strings.TrimSpace(meta.Date)); err == nil {
+			meta.Time = t
+		}
+	}
+
+	return meta
+}
+
+// URL returns the page's URL relative to the site root, such as /posts/hello/.
+func (m pageMeta) URL() string {
+	return outputURL(m.Path)
+}
+
+// Href returns a link to the page using the @pages and @posts aliases, which
+// resolvePaths turns into a relative link from wherever it is used.
+func (m pageMeta) Href() string {
+	switch {
+	case m.Section == "pages" && m.ID == "index":
+		// The index page is built at the root of the output directory
+		return "@pages/index/" + m.Path
+	case m.Section == "pages":
+		return "@pages/" + m.Path
+	case m.Section == "posts" || m.Section == "archive" || m.Section == "series":
+		return "@" + m.Path
+	default:
+		return m.URL()
+	}
+}
+
+// outputURL turns a path relative to the output directory into a URL relative to
+// the site root. index.html files are served as their folder.
+func outputURL(outputPath string) string {
+	if path.Base(outputPath) == "index.html" {
+		dir := path.Dir(outputPath)
+		if dir == "." {
+			return "/"
+		}
+		return "/" + dir + "/"
+	}
+	return "/" + outputPath
+}
+
+// hrefURL resolves a link on the page at pagePath to a URL relative to the site
+// root, or returns an empty string for links that leave the site.
+func hrefURL(href, pagePath string) string {
+	href, _, _ = strings.Cut(href, "#")
+	href, _, _ = strings.Cut(href, "?")
+	if href == "" || strings.HasPrefix(href, "//") || strings.Contains(href, ":") {
+		return ""
+	}
+
+	var target string
+	switch {
+	case strings.HasPrefix(href, "@pages/"):
+		// Pages are built without the pages folder, and the index page at the root
+		target = strings.TrimPrefix(href, "@pages/")
+		if target == "index" || strings.HasPrefix(target, "index/") {
+			target = strings.TrimPrefix(target, "index")
+		}
+	case strings.HasPrefix(href, "@posts/"):
+		target = "posts/" + strings.TrimPrefix(href, "@posts/")
+	case strings.HasPrefix(href, "@"):
+		return ""
+	case strings.HasPrefix(href, "/"):
+		target = strings.TrimPrefix(href, "/")
+	default:
+		target = path.Join(path.Dir(pagePath), href)
+	}
+
+	target = path.Clean("/" + target)[1:]
+	if target == "" || strings.HasSuffix(href, "/") || path.Ext(target) == "" {
+		target = path.Join(target, "index.html")
+	}
+	return outputURL(target)
+}
+
+// values returns the page variables available to value comments as page.<key>.
+// Every key is always defined, so shared components work on any page.
+func (m pageMeta) values(cfg *siteconfig.SiteConfig) map[string]any {
+	values := map[string]any{
+		"id":           m.ID,
+		"section":      m.Section,
+		"title":        m.Title,
+		"description":  m.Description,
+		"date":         m.Date,
+		"url":          m.URL(),
+		"href":         m.Href(),
+		"words":        m.Words,
+		"reading_time": m.ReadingTime,
+		"excerpt":      m.Excerpt,
+	}
+
+	tags := make([]any, len(m.Tags))
+	for i, tag := range m.Tags {
+		tags[i] = tag
+	}
+	values["tags"] = tags
+	values["series"] = m.Series
+
+	// An absolute link needs to know where the site is hosted, so it is empty without base_url
+	values["permalink"] = ""
+	if cfg.BaseURL != "" {
+		values["permalink"] = strings.TrimSuffix(cfg.BaseURL, "/") + m.URL()
+	}
+
+	return values
+}
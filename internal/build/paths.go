@@ -0,0 +1,61 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"velcro/internal/siteconfig"
+)
+
+// confinePath checks that path stays inside baseDir and that, once symlinks are
+// resolved, it still points somewhere inside the project root. Links leading out
+// of the project are only allowed when follow_symlinks is enabled.
+func confinePath(path, baseDir string, cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
+	if !isWithin(baseDir, path) {
+		return fmt.Errorf("%q is outside of %q", path, baseDir)
+	}
+
+	if cfg.FollowSymlinks {
+		return nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Nothing to follow, reading the file will report the missing path
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	root, err := filepath.EvalSymlinks(opts.RootDir)
+	if err != nil {
+		return err
+	}
+
+	if !isWithin(root, resolved) {
+		return fmt.Errorf("%q links to %q outside of the project (set follow_symlinks = true to allow this)", path, resolved)
+	}
+
+	return nil
+}
+
+// isWithin reports whether path is baseDir itself or somewhere below it.
+func isWithin(baseDir, path string) bool {
+	absBase, err := filepath.Abs(baseDir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(absBase, absPath)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
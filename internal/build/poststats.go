@@ -0,0 +1,114 @@
+package build
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"velcro/internal/siteconfig"
+
+	"golang.org/x/net/html"
+)
+
+// postsJSONFile is written to the root of the output directory.
+const postsJSONFile = "posts.json"
+
+// wordsPerMinute is the reading speed used for reading times.
+const wordsPerMinute = 200
+
+// morePattern matches the <!-- more --> comment that ends a post's excerpt.
+var morePattern = regexp.MustCompile(`^\s*more\s*$`)
+
+// postEntry is one post in posts.json.
+type postEntry struct {
+	// URL is relative to posts.json
+	URL         string `json:"url"`
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Date        string `json:"date,omitempty"`
+	Words       int    `json:"words"`
+	ReadingTime int    `json:"reading_time"`
+	Excerpt     string `json:"excerpt,omitempty"`
+}
+
+// readContentStats counts the words of a page's body and estimates its reading
+// time in minutes. The excerpt is the text before <!-- more --> if the page has
+// one, or its first paragraph otherwise. Headings are left out of the excerpt.
+func readContentStats(doc *html.Node) (words, readingTime int, excerpt string) {
+	content := findElement(doc, "body")
+	if content == nil {
+		content = doc
+	}
+
+	var text, beforeMore []string
+	more := false
+	var collect func(*html.Node, bool)
+	collect = func(n *html.Node, inHeading bool) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode:
+				text = append(text, html.UnescapeString(c.Data))
+				if !more && !inHeading {
+					beforeMore = append(beforeMore, html.UnescapeString(c.Data))
+				}
+			case c.Type == html.CommentNode:
+				if morePattern.MatchString(c.Data) && !more {
+					more = true
+					excerpt = strings.Join(strings.Fields(strings.Join(beforeMore, " ")), " ")
+				}
+			case c.Type != html.ElementNode:
+			case searchSkippedTags[c.Data]:
+			default:
+				collect(c, inHeading || headingLevel(c) > 0)
+			}
+		}
+	}
+	collect(content, false)
+
+	words = len(strings.Fields(strings.Join(text, " ")))
+	if words > 0 {
+		readingTime = max(1, (words+wordsPerMinute/2)/wordsPerMinute)
+	}
+
+	if !more {
+		walkElements(content, func(el *html.Node) bool {
+			if excerpt == "" && el.Data == "p" {
+				excerpt = strings.Join(strings.Fields(textContent(el)), " ")
+			}
+			return excerpt == ""
+		})
+	}
+
+	return words, readingTime, excerpt
+}
+
+// buildPostsJSON writes posts.json listing every post, newest first, for scripts
+// and tools that need post metadata without parsing the built pages.
+func buildPostsJSON(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
+	if !cfg.PostsJSON {
+		return nil
+	}
+
+	entries := make([]postEntry, 0, len(opts.posts))
+	for _, post := range opts.posts {
+		entries = append(entries, postEntry{
+			URL:         post.Path,
+			ID:          post.ID,
+			Title:       post.Title,
+			Description: post.Description,
+			Date:        post.Date,
+			Words:       post.Words,
+			ReadingTime: post.ReadingTime,
+			Excerpt:     post.Excerpt,
+		})
+	}
+
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(opts.resolve(cfg.OutputDir), postsJSONFile), content, 0644)
+}
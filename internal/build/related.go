@@ -0,0 +1,115 @@
+package build
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"velcro/internal/siteconfig"
+
+	"golang.org/x/net/html"
+)
+
+// The @prevnext links and the markup of each @related post, unless the project
+// has its own components/prevnext.html or components/related-item.html.
+const (
+	defaultPrevNext = `<nav class="prevnext" data-if="prevnext" aria-label="Previous and next posts">
+    <a data-if="prevnext.prev" data-value-href="prevnext.prev.href" rel="prev">Previous: <!-- value="prevnext.prev.title" --></a>
+    <a data-if="prevnext.next" data-value-href="prevnext.next.href" rel="next">Next: <!-- value="prevnext.next.title" --></a>
+</nav>
+`
+	defaultRelatedItem = `<article class="related-item">
+    <a data-value-href="post.href"><!-- value="post.title" --></a>
+</article>
+`
+)
+
+// addPostValues makes the neighbours and related posts of a post available as
+// prevnext.prev, prevnext.next and related. prev is the older post and next the
+// newer one.
+func addPostValues(state *pageState, cfg *siteconfig.SiteConfig, opts *BuildOptions) {
+	i := slices.IndexFunc(opts.posts, func(post pageMeta) bool { return post.ID == state.page.ID })
+	if i < 0 {
+		return
+	}
+
+	// Posts are sorted newest first
+	prevnext := make(map[string]any)
+	if i+1 < len(opts.posts) {
+		prevnext["prev"] = opts.posts[i+1].values(cfg)
+	}
+	if i > 0 {
+		prevnext["next"] = opts.posts[i-1].values(cfg)
+	}
+	state.values["prevnext"] = prevnext
+
+	related := make([]any, 0, cfg.RelatedPosts)
+	for _, post := range relatedPosts(opts.posts, opts.posts[i], cfg.RelatedPosts) {
+		related = append(related, post.values(cfg))
+	}
+	state.values["related"] = related
+}
+
+// relatedPosts returns up to limit posts sharing the most tags with post. Ties
+// keep the order of posts, so newer posts come first.
+func relatedPosts(posts []pageMeta, post pageMeta, limit int) []pageMeta {
+	tags := make(map[string]bool)
+	for _, tag := range post.Tags {
+		tags[strings.ToLower(tag)] = true
+	}
+
+	type scored struct {
+		post   pageMeta
+		shared int
+	}
+	var candidates []scored
+	for _, other := range posts {
+		if other.ID == post.ID {
+			continue
+		}
+
+		shared := 0
+		for _, tag := range other.Tags {
+			if tags[strings.ToLower(tag)] {
+				shared++
+			}
+		}
+		if shared > 0 {
+			candidates = append(candidates, scored{other, shared})
+		}
+	}
+
+	slices.SortStableFunc(candidates, func(a, b scored) int {
+		return b.shared - a.shared
+	})
+
+	related := make([]pageMeta, 0, min(limit, len(candidates)))
+	for _, c := range candidates[:min(limit, len(candidates))] {
+		related = append(related, c.post)
+	}
+	return related
+}
+
+// renderPostLinks renders the @prevnext or @related include of a post.
+func renderPostLinks(includePath string, at *html.Node, cfg *siteconfig.SiteConfig, opts *BuildOptions, state *pageState) (*html.Node, error) {
+	related, ok := state.values["related"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s can only be used in a post", state.pos(at), includePath)
+	}
+
+	if includePath == "@prevnext" {
+		return renderUserComponent("prevnext", defaultPrevNext, at, cfg, opts, state)
+	}
+
+	list := &html.Node{Type: html.DocumentNode}
+	for _, post := range related {
+		state.values["post"] = post
+		item, err := renderUserComponent("related-item", defaultRelatedItem, at, cfg, opts, state)
+		delete(state.values, "post")
+		if err != nil {
+			return nil, err
+		}
+
+		appendChildren(list, item)
+	}
+	return list, nil
+}
@@ -0,0 +1,71 @@
+package build
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"velcro/internal/siteconfig"
+)
+
+// expiresMetaName is the meta tag that unpublishes a post from a date on, e.g.
+// <meta name="velcro:expires" content="2026-01-31">.
+const expiresMetaName = "velcro:expires"
+
+// filterPublished drops the posts that are not published at opts.Now: drafts, whose
+// folder starts with draft_prefix, posts dated after that day unless opts.Future is
+// set, and posts whose velcro:expires has passed. It returns the published posts
+// and the IDs of the others.
+func filterPublished(posts []pageMeta, cfg *siteconfig.SiteConfig, opts *BuildOptions) ([]pageMeta, map[string]bool, error) {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	// Post dates are days, so compare against the day of now rather than its time
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var published []pageMeta
+	unpublished := make(map[string]bool)
+	for _, post := range posts {
+		if cfg.DraftPrefix != "" && strings.HasPrefix(post.ID, cfg.DraftPrefix) {
+			slog.Info("Skipping draft post", "post", post.ID)
+			unpublished[post.ID] = true
+			continue
+		}
+
+		if !opts.Future && post.Time.After(today) {
+			slog.Info("Skipping scheduled post", "post", post.ID, "date", post.Date)
+			unpublished[post.ID] = true
+			continue
+		}
+
+		if post.Expires != "" {
+			expired, err := isExpired(post.Expires, now, today)
+			if err != nil {
+				return nil, nil, fmt.Errorf("post %s: %w", post.ID, err)
+			}
+			if expired {
+				slog.Info("Skipping expired post", "post", post.ID, "expires", post.Expires)
+				unpublished[post.ID] = true
+				continue
+			}
+		}
+
+		published = append(published, post)
+	}
+
+	return published, unpublished, nil
+}
+
+// isExpired reports whether a velcro:expires value has passed. A date such as
+// 2026-01-31 expires at the start of that day, a full RFC 3339 time at that moment.
+func isExpired(expires string, now, today time.Time) (bool, error) {
+	expires = strings.TrimSpace(expires)
+	if t, err := time.Parse(time.DateOnly, expires); err == nil {
+		return !today.Before(t), nil
+	}
+	if t, err := time.Parse(time.RFC3339, expires); err == nil {
+		return !now.Before(t), nil
+	}
+	return false, fmt.Errorf("invalid %s %q, use YYYY-MM-DD or an RFC 3339 time", expiresMetaName, expires)
+}
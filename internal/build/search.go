@@ -0,0 +1,115 @@
+package build
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"velcro/internal/siteconfig"
+
+	"golang.org/x/net/html"
+)
+
+// searchIndexFile is written to the root of the output directory.
+const searchIndexFile = "search-index.json"
+
+// searchSkippedTags are left out of the indexed text, as they hold code or the
+// navigation and footer repeated on every page rather than the post itself.
+var searchSkippedTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"nav":      true,
+	"header":   true,
+	"footer":   true,
+}
+
+// searchEntry is one post in search-index.json.
+type searchEntry struct {
+	// URL is relative to search-index.json
+	URL         string   `json:"url"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Date        string   `json:"date,omitempty"`
+	Headings    []string `json:"headings,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Text        string   `json:"text"`
+}
+
+// buildSearchIndex writes search-index.json from the built posts so a search
+// component can query it in the browser.
+func buildSearchIndex(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
+	if !cfg.SearchIndex {
+		return nil
+	}
+
+	outputDir := opts.resolve(cfg.OutputDir)
+	entries := make([]searchEntry, 0, len(opts.posts))
+	for _, post := range opts.posts {
+		builtPath := filepath.Join(outputDir, filepath.FromSlash(post.Path))
+		content, err := os.ReadFile(builtPath)
+		if err != nil {
+			return err
+		}
+
+		doc, err := parseHTML(content, builtPath, newPageState())
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, newSearchEntry(doc, post))
+	}
+
+	index, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(outputDir, searchIndexFile), index, 0644)
+}
+
+// newSearchEntry extracts the searchable parts of a built post. The text comes
+// from <main> if there is one, or <body> otherwise.
+func newSearchEntry(doc *html.Node, post pageMeta) searchEntry {
+	entry := searchEntry{
+		URL:         post.Path,
+		Title:       post.Title,
+		Description: post.Description,
+		Date:        post.Date,
+		Tags:        post.Tags,
+	}
+
+	content := findElement(doc, "main")
+	if content == nil {
+		content = findElement(doc, "body")
+	}
+	if content == nil {
+		return entry
+	}
+
+	var text []string
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode:
+				text = append(text, html.UnescapeString(c.Data))
+			case c.Type != html.ElementNode:
+			case searchSkippedTags[c.Data]:
+			case c.Data == "a" && attrValue(c, "class") == headingAnchorClass:
+			case c.Data == "h1" || c.Data == "h2" || c.Data == "h3" || c.Data == "h4" || c.Data == "h5" || c.Data == "h6":
+				if heading := headingText(c); heading != "" {
+					entry.Headings = append(entry.Headings, heading)
+				}
+				collect(c)
+			default:
+				collect(c)
+			}
+		}
+	}
+	collect(content)
+
+	entry.Text = strings.Join(strings.Fields(strings.Join(text, " ")), " ")
+	return entry
+}
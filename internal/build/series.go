@@ -0,0 +1,184 @@
+package build
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"velcro/internal/siteconfig"
+
+	"golang.org/x/net/html"
+)
+
+// Meta tags that put a post into a series, e.g.
+// <meta name="velcro:series" content="go-generics">
+// <meta name="velcro:series-part" content="2">
+// <meta name="velcro:series-title" content="Go generics from scratch">
+const (
+	seriesMetaName      = "velcro:series"
+	seriesPartMetaName  = "velcro:series-part"
+	seriesTitleMetaName = "velcro:series-title"
+)
+
+// defaultSeriesItem is one part in the @series list, overridden by
+// components/series-item.html.
+const defaultSeriesItem = `<li><a data-value-href="post.href"><!-- value="post.title" --></a></li>
+`
+
+// series is the posts sharing a velcro:series, in reading order.
+type series struct {
+	name  string
+	title string
+	posts []pageMeta
+}
+
+// href links to the series index page.
+func (s series) href() string {
+	return "@series/" + Slugify(s.name)
+}
+
+// groupPostsBySeries collects the series of posts, sorted by name. Parts are
+// ordered by velcro:series-part, with parts that have none last, then oldest first.
+func groupPostsBySeries(posts []pageMeta) []series {
+	byName := make(map[string]*series)
+	var all []*series
+	for _, post := range posts {
+		if post.Series == "" {
+			continue
+		}
+
+		s, ok := byName[post.Series]
+		if !ok {
+			s = &series{name: post.Series, title: post.Series}
+			byName[post.Series] = s
+			all = append(all, s)
+		}
+		if post.SeriesTitle != "" {
+			s.title = post.SeriesTitle
+		}
+		s.posts = append(s.posts, post)
+	}
+
+	result := make([]series, 0, len(all))
+	for _, s := range all {
+		slices.SortStableFunc(s.posts, func(a, b pageMeta) int {
+			switch {
+			case a.SeriesPart != b.SeriesPart && a.SeriesPart == 0:
+				return 1
+			case a.SeriesPart != b.SeriesPart && b.SeriesPart == 0:
+				return -1
+			case a.SeriesPart != b.SeriesPart:
+				return a.SeriesPart - b.SeriesPart
+			}
+			// Same-day parts keep the ID order they were collected in
+			return a.Time.Compare(b.Time)
+		})
+		result = append(result, *s)
+	}
+
+	slices.SortFunc(result, func(a, b series) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return result
+}
+
+// addSeriesValues makes the series of a post available as series.name,
+// series.title, series.href, series.part and series.parts. series is empty for
+// posts outside of a series, so data-if="series" can hide a series box.
+func addSeriesValues(state *pageState, opts *BuildOptions) {
+	values := make(map[string]any)
+	state.values["series"] = values
+	if state.page.Series == "" {
+		return
+	}
+
+	for _, s := range groupPostsBySeries(opts.posts) {
+		if s.name != state.page.Series {
+			continue
+		}
+
+		part := slices.IndexFunc(s.posts, func(post pageMeta) bool { return post.ID == state.page.ID })
+		values["name"] = s.name
+		values["title"] = s.title
+		values["href"] = s.href()
+		values["part"] = part + 1
+		values["parts"] = len(s.posts)
+	}
+}
+
+// renderSeriesParts renders the series-item component once for every part of the
+// current post's series. Links inside a <nav> are matched against the current
+// page like any other, so the current part gets active_class.
+func renderSeriesParts(at *html.Node, cfg *siteconfig.SiteConfig, opts *BuildOptions, state *pageState) (*html.Node, error) {
+	if _, ok := state.values["series"]; !ok {
+		return nil, fmt.Errorf("%s: @series can only be used in a post", state.pos(at))
+	}
+
+	list := &html.Node{Type: html.DocumentNode}
+	for _, s := range groupPostsBySeries(opts.posts) {
+		if s.name != state.page.Series {
+			continue
+		}
+
+		for i, post := range s.posts {
+			values := post.values(cfg)
+			values["part"] = i + 1
+			state.values["post"] = values
+			item, err := renderUserComponent("series-item", defaultSeriesItem, at, cfg, opts, state)
+			delete(state.values, "post")
+			if err != nil {
+				return nil, err
+			}
+
+			appendChildren(list, item)
+		}
+	}
+	return list, nil
+}
+
+// buildSeries writes series/index.html listing every series and
+// series/<name>/index.html listing the parts of each in reading order.
+func buildSeries(cfg *siteconfig.SiteConfig, opts *BuildOptions) error {
+	err := removeListPages("series", cfg, opts)
+	if err != nil {
+		return err
+	}
+	if !cfg.Series {
+		return nil
+	}
+
+	all := groupPostsBySeries(opts.posts)
+	if len(all) == 0 {
+		return nil
+	}
+
+	// Series names become folder names, so two names must not end up the same
+	dirs := make(map[string]string)
+	for _, s := range all {
+		dir := Slugify(s.name)
+		if dir == "" {
+			return fmt.Errorf("series %q needs a letter or digit in its name to be written to series/<name>/", s.name)
+		}
+		if other, ok := dirs[dir]; ok {
+			return fmt.Errorf("series %q and %q would both be written to series/%s/", other, s.name, dir)
+		}
+		dirs[dir] = s.name
+	}
+
+	var links strings.Builder
+	for _, s := range all {
+		fmt.Fprintf(&links, "        <li><a href=\"%s\">%s</a> (%d)</li>\n", s.href(), html.EscapeString(s.title), len(s.posts))
+	}
+	err = renderListPage("series", "Series", links.String(), nil, cfg, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range all {
+		err := renderListPage("series", s.title, "", s.posts, cfg, opts, Slugify(s.name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
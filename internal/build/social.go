@@ -0,0 +1,133 @@
+package build
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"velcro/internal/siteconfig"
+
+	"golang.org/x/net/html"
+)
+
+// coverExtensions are the image types picked up as a post's default og:image.
+var coverExtensions = []string{".jpg", ".jpeg", ".png", ".webp", ".gif", ".avif"}
+
+// addSocialTags adds Open Graph and Twitter card tags and a canonical link to the
+// head of a page, from its title, description and date. Tags the author wrote in
+// the page or its layout are kept as they are. URLs need base_url, as sharing sites
+// only accept absolute ones.
+func addSocialTags(doc *html.Node, state *pageState, srcDir string, cfg *siteconfig.SiteConfig) {
+	head := findElement(doc, "head")
+	if !cfg.SocialTags || head == nil {
+		return
+	}
+
+	page := state.page
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+
+	var tags []*html.Node
+	addMeta := func(attr, key, content string) {
+		if content == "" || hasMetaTag(head, key) {
+			return
+		}
+		tags = append(tags, newElement("meta",
+			html.Attribute{Key: attr, Val: key},
+			html.Attribute{Key: "content", Val: content},
+		))
+	}
+
+	ogType := "website"
+	if page.Section == "posts" {
+		ogType = "article"
+	}
+
+	var permalink string
+	if baseURL != "" {
+		permalink = baseURL + page.URL()
+	}
+	image := coverImageURL(page, srcDir, cfg)
+
+	addMeta("property", "og:title", page.Title)
+	addMeta("property", "og:description", page.Description)
+	addMeta("property", "og:url", permalink)
+	addMeta("property", "og:type", ogType)
+	if ogType == "article" && !page.Time.IsZero() {
+		addMeta("property", "article:published_time", page.Time.Format(time.DateOnly))
+	}
+	addMeta("property", "og:image", image)
+
+	card := "summary"
+	if image != "" || hasMetaTag(head, "og:image") {
+		card = "summary_large_image"
+	}
+	addMeta("name", "twitter:card", card)
+
+	if permalink != "" && !hasCanonicalLink(head) {
+		tags = append(tags, newElement("link",
+			html.Attribute{Key: "rel", Val: "canonical"},
+			html.Attribute{Key: "href", Val: permalink},
+		))
+	}
+
+	appendIndented(head, tags)
+}
+
+// hasMetaTag reports whether a <meta> tag with the given property or name exists
+// below n. Open Graph uses property and Twitter uses name, but both are common.
+func hasMetaTag(n *html.Node, key string) bool {
+	found := false
+	walkElements(n, func(el *html.Node) bool {
+		if el.Data == "meta" && (strings.EqualFold(attrValue(el, "property"), key) || strings.EqualFold(attrValue(el, "name"), key)) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// hasCanonicalLink reports whether a <link rel="canonical"> exists below n.
+func hasCanonicalLink(n *html.Node) bool {
+	found := false
+	walkElements(n, func(el *html.Node) bool {
+		if el.Data == "link" && slices.Contains(strings.Fields(strings.ToLower(attrValue(el, "rel"))), "canonical") {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// coverImageURL returns the absolute URL of a post's cover.* image, or an empty
+// string if it has none or base_url is not set.
+func coverImageURL(page pageMeta, srcDir string, cfg *siteconfig.SiteConfig) string {
+	if cfg.BaseURL == "" || page.Section != "posts" {
+		return ""
+	}
+
+	cover := findCoverImage(srcDir)
+	if cover == "" {
+		return ""
+	}
+	return strings.TrimSuffix(cfg.BaseURL, "/") + "/" + path.Join(path.Dir(page.Path), cover)
+}
+
+// findCoverImage returns the name of the cover.* image in dir, or an empty string
+// if there is none.
+func findCoverImage(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if !entry.IsDir() && strings.TrimSuffix(name, ext) == "cover" && slices.Contains(coverExtensions, strings.ToLower(ext)) {
+			return name
+		}
+	}
+	return ""
+}
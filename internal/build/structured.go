@@ -0,0 +1,147 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"velcro/internal/siteconfig"
+
+	"golang.org/x/net/html"
+)
+
+// jsonLDType is the script type of JSON-LD structured data.
+const jsonLDType = "application/ld+json"
+
+// addStructuredData adds a JSON-LD block to the head of posts (BlogPosting) and the
+// home page (WebSite and Person), built from the page metadata, the author in
+// [site] and base_url. Pages that already have a JSON-LD block are left alone, but
+// their blocks have to be valid JSON.
+func addStructuredData(doc *html.Node, state *pageState, srcDir string, cfg *siteconfig.SiteConfig) error {
+	head := findElement(doc, "head")
+	if !cfg.StructuredData || head == nil {
+		return nil
+	}
+
+	found := false
+	var invalidErr error
+	walkElements(doc, func(el *html.Node) bool {
+		if el.Data != "script" || !strings.EqualFold(strings.TrimSpace(attrValue(el, "type")), jsonLDType) {
+			return invalidErr == nil
+		}
+		found = true
+
+		// Script contents are not HTML, so the raw text is the JSON
+		var content strings.Builder
+		for c := el.FirstChild; c != nil; c = c.NextSibling {
+			content.WriteString(c.Data)
+		}
+		var v any
+		if err := json.Unmarshal([]byte(content.String()), &v); err != nil {
+			invalidErr = fmt.Errorf("%s: JSON-LD block is not valid JSON: %w", state.pos(el), err)
+		}
+		return false
+	})
+	if invalidErr != nil || found {
+		return invalidErr
+	}
+
+	var data map[string]any
+	switch page := state.page; {
+	case page.Section == "posts":
+		data = blogPosting(page, srcDir, cfg)
+	case page.Section == "pages" && page.ID == "index" && page.Path == "index.html":
+		data = webSite(page, cfg)
+	default:
+		return nil
+	}
+
+	// Marshal escapes <, > and &, so the block can never close its own <script>
+	content, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON-LD: %w", err)
+	}
+
+	script := newElement("script", html.Attribute{Key: "type", Val: jsonLDType})
+	script.AppendChild(&html.Node{Type: html.TextNode, Data: string(content)})
+	appendIndented(head, []*html.Node{script})
+	return nil
+}
+
+// blogPosting describes a post as a schema.org BlogPosting.
+func blogPosting(page pageMeta, srcDir string, cfg *siteconfig.SiteConfig) map[string]any {
+	data := map[string]any{
+		"@context":  "https://schema.org",
+		"@type":     "BlogPosting",
+		"headline":  page.Title,
+		"wordCount": page.Words,
+	}
+	if page.Description != "" {
+		data["description"] = page.Description
+	}
+	if !page.Time.IsZero() {
+		data["datePublished"] = page.Time.Format(time.DateOnly)
+	}
+	if cfg.BaseURL != "" {
+		permalink := strings.TrimSuffix(cfg.BaseURL, "/") + page.URL()
+		data["url"] = permalink
+		data["mainEntityOfPage"] = permalink
+	}
+	if image := coverImageURL(page, srcDir, cfg); image != "" {
+		data["image"] = image
+	}
+	if author := siteAuthor(cfg); author != nil {
+		data["author"] = author
+	}
+	return data
+}
+
+// webSite describes the site as a schema.org WebSite, along with its author as a
+// Person when [site] names one. The WebSite refers to the Person by its @id.
+func webSite(page pageMeta, cfg *siteconfig.SiteConfig) map[string]any {
+	site := map[string]any{
+		"@type": "WebSite",
+	}
+	if title, ok := cfg.Site["title"].(string); ok && title != "" {
+		site["name"] = title
+	} else if page.Title != "" {
+		site["name"] = page.Title
+	}
+	if page.Description != "" {
+		site["description"] = page.Description
+	}
+	if cfg.BaseURL != "" {
+		site["url"] = strings.TrimSuffix(cfg.BaseURL, "/") + "/"
+	}
+
+	graph := []any{site}
+	if author := siteAuthor(cfg); author != nil {
+		site["author"] = map[string]any{"@id": author["@id"]}
+		graph = append(graph, author)
+	}
+
+	return map[string]any{
+		"@context": "https://schema.org",
+		"@graph":   graph,
+	}
+}
+
+// siteAuthor returns site.author as a schema.org Person, linked to site.author_url
+// if set, or nil if there is no author. Its @id is the same on every page so
+// search engines see one person.
+func siteAuthor(cfg *siteconfig.SiteConfig) map[string]any {
+	name, _ := cfg.Site["author"].(string)
+	if name == "" {
+		return nil
+	}
+
+	id := "#author"
+	if cfg.BaseURL != "" {
+		id = strings.TrimSuffix(cfg.BaseURL, "/") + "/#author"
+	}
+	person := map[string]any{"@type": "Person", "@id": id, "name": name}
+	if url, ok := cfg.Site["author_url"].(string); ok && url != "" {
+		person["url"] = url
+	}
+	return person
+}
@@ -0,0 +1,252 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"velcro/internal/siteconfig"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/net/html"
+)
+
+// valuePattern matches <!-- value="site.author" --> comments. Values are HTML
+// escaped unless the comment ends in raw, as in <!-- value="site.analytics" raw -->.
+var valuePattern = regexp.MustCompile(`^\s*value\s*=\s*"([^"]+)"(\s+raw)?\s*$`)
+
+// valueAttributePrefix marks attributes filled from a value, as comments cannot be
+// used inside attributes. <a data-value-href="page.url"> becomes <a href="/posts/hello/">.
+const valueAttributePrefix = "data-value-"
+
+// loadValues collects the variables available to value comments: the [site] table
+// from the config under "site" and every file in the data directory under "data".
+func loadValues(cfg *siteconfig.SiteConfig, opts *BuildOptions) (map[string]any, error) {
+	site := cfg.Site
+	if site == nil {
+		site = make(map[string]any)
+	}
+
+	data := make(map[string]any)
+	dataDir := opts.resolve(cfg.Dirs.Data)
+	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
+		return map[string]any{"site": site, "data": data}, nil
+	}
+
+	err := filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".toml" && ext != ".json" {
+			slog.Warn("Skipping data file that is not TOML or JSON", "file", path)
+			return nil
+		}
+
+		if err := confinePath(path, dataDir, cfg, opts); err != nil {
+			return err
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var fileData any
+		if ext == ".toml" {
+			var table map[string]any
+			_, err = toml.Decode(string(content), &table)
+			fileData = table
+		} else {
+			// Keep numbers as written instead of converting them to floats
+			decoder := json.NewDecoder(bytes.NewReader(content))
+			decoder.UseNumber()
+			err = decoder.Decode(&fileData)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read data file %s: %w", path, err)
+		}
+
+		// data/social/links.toml is available as data.social.links
+		rel, _ := filepath.Rel(dataDir, path)
+		parts := strings.Split(filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel))), "/")
+		parent := data
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := parent[part].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				parent[part] = child
+			}
+			parent = child
+		}
+
+		name := parts[len(parts)-1]
+		if _, ok := parent[name]; ok {
+			return fmt.Errorf("data file %s clashes with another data file or folder named %q", path, name)
+		}
+		parent[name] = fileData
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"site": site, "data": data}, nil
+}
+
+// lookupValue finds a dotted key such as "data.social.links.0.url" in values.
+// Numeric parts index into lists.
+func lookupValue(values map[string]any, key string) (any, error) {
+	var current any = values
+	for _, part := range strings.Split(key, ".") {
+		switch v := current.(type) {
+		case map[string]any:
+			next, ok := v[part]
+			if !ok {
+				return nil, fmt.Errorf("undefined value %q", key)
+			}
+			current = next
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("undefined value %q", key)
+			}
+			current = v[i]
+		case []map[string]any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("undefined value %q", key)
+			}
+			current = v[i]
+		default:
+			return nil, fmt.Errorf("undefined value %q", key)
+		}
+	}
+	return current, nil
+}
+
+// formatValue turns a value into text. Tables and lists have no single text form
+// so they are reported as errors.
+func formatValue(key string, value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 && v.Nanosecond() == 0 {
+			return v.Format(time.DateOnly), nil
+		}
+		return v.Format(time.RFC3339), nil
+	case map[string]any:
+		return "", fmt.Errorf("value %q is a table, pick one of its keys", key)
+	case []any, []map[string]any:
+		return "", fmt.Errorf("value %q is a list, pick one of its items by index", key)
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// processDataIf handles the data-if attribute, which keeps an element only when
+// the value it names is set and not empty, false or zero. It reports whether the
+// element should be kept.
+func processDataIf(el *html.Node, state *pageState) (bool, error) {
+	key, ok := getAttr(el, "data-if")
+	if !ok {
+		return true, nil
+	}
+	removeAttr(el, "data-if")
+
+	value, err := lookupValue(state.values, key)
+	if err != nil {
+		// Missing keys are what data-if is for, but a missing table is likely a typo
+		parent, _, _ := strings.Cut(key, ".")
+		if _, ok := state.values[parent]; !ok {
+			return false, fmt.Errorf("%s: %w", state.pos(el), err)
+		}
+		return false, nil
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return false, nil
+	case string:
+		return v != "", nil
+	case bool:
+		return v, nil
+	case int:
+		return v != 0, nil
+	case int64:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	case json.Number:
+		// Numbers from JSON data files, which are decoded with UseNumber
+		f, err := v.Float64()
+		return err != nil || f != 0, nil
+	case map[string]any:
+		return len(v) > 0, nil
+	case []any:
+		return len(v) > 0, nil
+	case []map[string]any:
+		return len(v) > 0, nil
+	default:
+		return true, nil
+	}
+}
+
+// expandValueAttributes sets every data-value-<name> attribute of el as <name>.
+func expandValueAttributes(el *html.Node, state *pageState) error {
+	for _, attr := range slices.Clone(el.Attr) {
+		name, ok := strings.CutPrefix(attr.Key, valueAttributePrefix)
+		if !ok || attr.Namespace != "" || name == "" {
+			continue
+		}
+
+		value, err := lookupValue(state.values, attr.Val)
+		if err != nil {
+			return fmt.Errorf("%s: %w", state.pos(el), err)
+		}
+
+		text, err := formatValue(attr.Val, value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", state.pos(el), err)
+		}
+
+		// Attribute values are escaped when rendered
+		removeAttr(el, attr.Key)
+		setAttr(el, name, text)
+	}
+	return nil
+}
+
+// expandValue replaces a value comment with the text of the value it names.
+func expandValue(c *html.Node, key string, raw bool, state *pageState) error {
+	value, err := lookupValue(state.values, key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", state.pos(c), err)
+	}
+
+	text, err := formatValue(key, value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", state.pos(c), err)
+	}
+
+	// Text nodes hold raw HTML, so escaping here is what makes the value plain text
+	if !raw {
+		text = html.EscapeString(text)
+	}
+
+	c.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: text}, c)
+	c.Parent.RemoveChild(c)
+	return nil
+}
@@ -0,0 +1,297 @@
+package siteconfig
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// CurrentVersion is the config version written by velcro init. Bump it and add a
+// migration below whenever keys are added, renamed or moved.
+const CurrentVersion = 13
+
+type Migration struct {
+	Version     int
+	Description string
+	apply       func(f *configFile)
+}
+
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "move draft_prefix out of [dirs], add follow_symlinks, dirs.scaffolds and [images]",
+		apply: func(f *configFile) {
+			f.moveKey("dirs", "draft_prefix", "", "draft_prefix")
+			f.setDefault("", "follow_symlinks", "false")
+			f.setDefault("dirs", "scaffolds", `"./scaffolds"`)
+			f.setDefault("images", "widths", "[480, 960, 1440]")
+			f.setDefault("images", "sizes", `"(max-width: 960px) 100vw, 960px"`)
+			f.setDefault("images", "eager", "1")
+			f.setDefault("images", "quality", "82")
+		},
+	},
+	{
+		Version:     2,
+		Description: "document base_url",
+		apply: func(f *configFile) {
+			f.setExample("", "base_url", `"https://example.com"`)
+		},
+	},
+	{
+		Version:     3,
+		Description: "add [site] title and author, document dirs.data",
+		apply: func(f *configFile) {
+			f.setExample("dirs", "data", `"./src/data"`)
+			f.setTemplateDefault("site", "title", "")
+			f.setTemplateDefault("site", "author", "")
+		},
+	},
+	{
+		Version:     4,
+		Description: "add active_class",
+		apply: func(f *configFile) {
+			f.setDefault("", "active_class", `"active"`)
+		},
+	},
+	{
+		Version:     5,
+		Description: "add [pagination]",
+		apply: func(f *configFile) {
+			f.setDefault("pagination", "page_size", "10")
+		},
+	},
+	{
+		Version:     6,
+		Description: "add archives, as the template sets it",
+		apply: func(f *configFile) {
+			f.setTemplateDefault("", "archives", "false")
+		},
+	},
+	{
+		Version:     7,
+		Description: "add search_index, as the template sets it",
+		apply: func(f *configFile) {
+			f.setTemplateDefault("", "search_index", "false")
+		},
+	},
+	{
+		Version:     8,
+		Description: "add [headings]",
+		apply: func(f *configFile) {
+			f.setTemplateDefault("headings", "anchors", "false")
+		},
+	},
+	{
+		Version:     9,
+		Description: "add posts_json, as the template sets it",
+		apply: func(f *configFile) {
+			f.setTemplateDefault("", "posts_json", "false")
+		},
+	},
+	{
+		Version:     10,
+		Description: "add social_tags, as the template sets it",
+		apply: func(f *configFile) {
+			f.setTemplateDefault("", "social_tags", "false")
+		},
+	},
+	{
+		Version:     11,
+		Description: "add structured_data, as the template sets it",
+		apply: func(f *configFile) {
+			f.setTemplateDefault("", "structured_data", "false")
+		},
+	},
+	{
+		Version:     12,
+		Description: "add related_posts",
+		apply: func(f *configFile) {
+			f.setDefault("", "related_posts", "3")
+		},
+	},
+	{
+		Version:     13,
+		Description: "add series, as the template sets it",
+		apply: func(f *configFile) {
+			f.setTemplateDefault("", "series", "false")
+		},
+	},
+}
+
+// MigrateConfig applies every migration newer than the config's version to the raw
+// TOML of a site config. templateConfig is the site config of the template the site
+// is upgraded to, new keys take the values it sets so features line up with the
+// template's files. It returns the updated file and the migrations that were
+// applied. Comments and untouched lines are kept as they are.
+func MigrateConfig(content, templateConfig string) (string, []Migration, error) {
+	var versioned struct {
+		Version int `toml:"version"`
+	}
+	if _, err := toml.Decode(content, &versioned); err != nil {
+		return "", nil, err
+	}
+
+	f := newConfigFile(content)
+	f.template = newConfigFile(templateConfig)
+	var applied []Migration
+	for _, migration := range migrations {
+		if migration.Version <= versioned.Version {
+			continue
+		}
+		migration.apply(f)
+		applied = append(applied, migration)
+	}
+
+	if len(applied) > 0 {
+		if i, ok := f.findKey("", "version"); ok {
+			f.lines[i] = "version = " + strconv.Itoa(CurrentVersion)
+		} else {
+			f.lines = slices.Insert(f.lines, 0, "# Config format version, used by velcro upgrade", "version = "+strconv.Itoa(CurrentVersion), "")
+		}
+	}
+
+	return f.String(), applied, nil
+}
+
+var (
+	tableHeaderPattern = regexp.MustCompile(`^\s*\[([^\[\]]+)\]\s*(#.*)?$`)
+	keyLinePattern     = regexp.MustCompile(`^\s*([A-Za-z0-9_-]+)\s*=\s*(.*)$`)
+)
+
+// configFile is a line based view of a TOML file, just enough to add, change and
+// move simple keys without losing the comments around them.
+type configFile struct {
+	lines []string
+	// template is the config of the template the site is upgraded to
+	template *configFile
+}
+
+func newConfigFile(content string) *configFile {
+	return &configFile{lines: strings.Split(content, "\n")}
+}
+
+func (f *configFile) String() string {
+	return strings.Join(f.lines, "\n")
+}
+
+// tableRange returns the lines belonging to a table, excluding its header. The
+// empty table name is the top level of the file.
+func (f *configFile) tableRange(table string) (int, int, bool) {
+	start := -1
+	if table == "" {
+		start = 0
+	}
+
+	for i, line := range f.lines {
+		match := tableHeaderPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if start >= 0 {
+			return start, i, true
+		}
+		if strings.TrimSpace(match[1]) == table {
+			start = i + 1
+		}
+	}
+
+	if start < 0 {
+		return 0, 0, false
+	}
+	return start, len(f.lines), true
+}
+
+// findKey returns the line index of a key in a table.
+func (f *configFile) findKey(table, key string) (int, bool) {
+	start, end, ok := f.tableRange(table)
+	if !ok {
+		return 0, false
+	}
+
+	for i := start; i < end; i++ {
+		if match := keyLinePattern.FindStringSubmatch(f.lines[i]); match != nil && match[1] == key {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// setDefault adds a key with the given raw TOML value unless it is already set.
+func (f *configFile) setDefault(table, key, value string) {
+	if _, ok := f.findKey(table, key); ok {
+		return
+	}
+	f.insertKey(table, key+" = "+value)
+}
+
+// setTemplateDefault adds a key with the raw value the template's config gives it,
+// or fallback when the template does not set it. An empty fallback leaves the key
+// out.
+func (f *configFile) setTemplateDefault(table, key, fallback string) {
+	value := fallback
+	if f.template != nil {
+		if i, ok := f.template.findKey(table, key); ok {
+			value = keyLinePattern.FindStringSubmatch(f.template.lines[i])[2]
+		}
+	}
+	if value == "" {
+		return
+	}
+	f.setDefault(table, key, value)
+}
+
+// setExample adds a key as a commented out line unless it is already set, for
+// optional keys that have no value every site should share.
+func (f *configFile) setExample(table, key, value string) {
+	if _, ok := f.findKey(table, key); ok {
+		return
+	}
+	f.insertKey(table, "# "+key+" = "+value)
+}
+
+// moveKey moves a key to a new table and/or name, keeping its value. If the
+// destination is already set the old key is dropped.
+func (f *configFile) moveKey(fromTable, fromKey, toTable, toKey string) {
+	i, ok := f.findKey(fromTable, fromKey)
+	if !ok {
+		return
+	}
+
+	// Take the comment lines directly above the key along with it
+	start := i
+	for start > 0 && strings.HasPrefix(strings.TrimSpace(f.lines[start-1]), "#") {
+		start--
+	}
+	comments := slices.Clone(f.lines[start:i])
+	value := keyLinePattern.FindStringSubmatch(f.lines[i])[2]
+	f.lines = slices.Delete(f.lines, start, i+1)
+
+	if _, ok := f.findKey(toTable, toKey); ok {
+		return
+	}
+	f.insertKey(toTable, append(comments, toKey+" = "+value)...)
+}
+
+// insertKey adds lines after the last key of a table, creating the table at the
+// end of the file if it does not exist yet.
+func (f *configFile) insertKey(table string, lines ...string) {
+	start, end, ok := f.tableRange(table)
+	if !ok {
+		f.lines = append(f.lines, "", "["+table+"]")
+		f.lines = append(f.lines, lines...)
+		return
+	}
+
+	// Commented out examples count as keys so new lines go after them
+	insertAt := start
+	for i := start; i < end; i++ {
+		line := strings.TrimPrefix(strings.TrimSpace(f.lines[i]), "#")
+		if keyLinePattern.MatchString(line) {
+			insertAt = i + 1
+		}
+	}
+	f.lines = slices.Insert(f.lines, insertAt, lines...)
+}
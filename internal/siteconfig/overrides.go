@@ -0,0 +1,177 @@
+package siteconfig
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// envPrefix marks environment variables that override config keys, such as
+// VELCRO_BASE_URL for base_url or VELCRO_IMAGES_QUALITY for images.quality.
+const envPrefix = "VELCRO_"
+
+// EnvVariable selects the [env.<name>] table when no environment is passed explicitly.
+const EnvVariable = envPrefix + "ENV"
+
+// Overrides are applied on top of site.config.toml, in order: the selected [env.<name>]
+// table, VELCRO_* environment variables and finally Values.
+type Overrides struct {
+	// Env is the name of the [env.<name>] table to apply, such as "production".
+	Env string
+	// Values are key=value pairs with dotted keys, such as "images.quality=90".
+	// Values are parsed as TOML and fall back to plain strings.
+	Values []string
+}
+
+var overrideKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+
+// sources records where config keys were set, so defaults are only applied to
+// keys that were left out everywhere.
+type sources struct {
+	file      toml.MetaData
+	env       string
+	overrides []toml.MetaData
+}
+
+func (s sources) isDefined(key ...string) bool {
+	if s.file.IsDefined(key...) {
+		return true
+	}
+	if s.env != "" && s.file.IsDefined(append([]string{"env", s.env}, key...)...) {
+		return true
+	}
+	return slices.ContainsFunc(s.overrides, func(md toml.MetaData) bool {
+		return md.IsDefined(key...)
+	})
+}
+
+// undecoded returns the keys that did not map onto SiteConfig anywhere.
+func (s sources) undecoded() []toml.Key {
+	keys := s.file.Undecoded()
+	for _, md := range s.overrides {
+		keys = append(keys, md.Undecoded()...)
+	}
+	return keys
+}
+
+// applyEnv decodes the [env.<name>] table over the config. Every other environment
+// is decoded into a scratch config so typos in it are reported too.
+func (c *SiteConfig) applyEnv(name string, md toml.MetaData) error {
+	if name != "" {
+		if _, ok := c.Env[name]; !ok {
+			return fmt.Errorf("no [env.%s] table found", name)
+		}
+	}
+
+	for envName, table := range c.Env {
+		target := c
+		if envName != name {
+			target = &SiteConfig{}
+		}
+		err := md.PrimitiveDecode(table, target)
+		if err != nil {
+			return fmt.Errorf("env.%s: %w", envName, err)
+		}
+	}
+
+	// The tables have been applied and would only clutter the effective config
+	c.Env = nil
+	return nil
+}
+
+// applyOverride sets a single dotted key. The value is parsed as TOML so numbers,
+// booleans and arrays work, anything else is used as a string.
+func (c *SiteConfig) applyOverride(key, value string) (toml.MetaData, error) {
+	if !overrideKeyPattern.MatchString(key) {
+		return toml.MetaData{}, fmt.Errorf("invalid key %q", key)
+	}
+
+	if !strings.ContainsAny(value, "\r\n") {
+		md, err := toml.Decode(key+" = "+value, c)
+		if err == nil {
+			return md, nil
+		}
+	}
+
+	quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(value)
+	return toml.Decode(key+` = "`+quoted+`"`, c)
+}
+
+// applyOverrides applies the selected environment, VELCRO_* environment variables
+// and key=value pairs, returning where each key was set.
+func (c *SiteConfig) applyOverrides(md toml.MetaData, overrides Overrides) (sources, error) {
+	env := overrides.Env
+	if env == "" {
+		env = os.Getenv(EnvVariable)
+	}
+	src := sources{file: md, env: env}
+
+	err := c.applyEnv(env, md)
+	if err != nil {
+		return src, err
+	}
+	if env != "" {
+		slog.Info("Using config environment", "env", env)
+	}
+
+	for _, variable := range environmentOverrides() {
+		name, value, _ := strings.Cut(variable, "=")
+		key, ok := envKey(name)
+		if !ok {
+			slog.Warn("Ignoring environment variable that does not match a config key", "name", name)
+			continue
+		}
+
+		overrideMD, err := c.applyOverride(key, value)
+		if err != nil {
+			return src, fmt.Errorf("%s: %w", name, err)
+		}
+		src.overrides = append(src.overrides, overrideMD)
+	}
+
+	for _, pair := range overrides.Values {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return src, fmt.Errorf("--set %q: expected key=value", pair)
+		}
+
+		overrideMD, err := c.applyOverride(strings.TrimSpace(key), value)
+		if err != nil {
+			return src, fmt.Errorf("--set %s: %w", key, err)
+		}
+		src.overrides = append(src.overrides, overrideMD)
+	}
+
+	return src, nil
+}
+
+// environmentOverrides returns the VELCRO_* environment variables as name=value,
+// apart from the environment selector.
+func environmentOverrides() []string {
+	var variables []string
+	for _, variable := range os.Environ() {
+		if strings.HasPrefix(variable, envPrefix) && !strings.HasPrefix(variable, EnvVariable+"=") {
+			variables = append(variables, variable)
+		}
+	}
+	slices.Sort(variables)
+	return variables
+}
+
+// envKey maps an environment variable name onto a config key by comparing it with
+// the known keys, as underscores are ambiguous between words and tables.
+func envKey(name string) (string, bool) {
+	want := strings.ToLower(strings.TrimPrefix(name, envPrefix))
+	for _, key := range knownKeys(reflect.TypeFor[SiteConfig](), "") {
+		if strings.ReplaceAll(key, ".", "_") == want {
+			return key, true
+		}
+	}
+	return "", false
+}
@@ -1,7 +1,10 @@
 package siteconfig
 
 import (
+	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
 
 	"github.com/BurntSushi/toml"
 )
@@ -14,23 +17,144 @@ type Dirs struct {
 	Styles     string `toml:"styles"`
 	Scripts    string `toml:"scripts"`
 	Components string `toml:"components"`
+	Scaffolds  string `toml:"scaffolds"`
+	// Data holds TOML and JSON files available to value comments as data.<file>.
+	Data string `toml:"data"`
+}
+
+type Images struct {
+	// Widths lists the pixel widths of the resized variants generated for each image.
+	// Leave empty to skip generating variants.
+	Widths []int `toml:"widths"`
+	// Sizes is the value emitted in the sizes attribute alongside srcset.
+	Sizes string `toml:"sizes"`
+	// Eager is the number of images at the top of a page that are not lazy loaded.
+	Eager int `toml:"eager"`
+	// Quality is the JPEG encoding quality (1-100) used for resized variants.
+	Quality int `toml:"quality"`
+}
+
+type Pagination struct {
+	// PageSize is the number of posts on each page of a post listing.
+	PageSize int `toml:"page_size"`
+}
+
+type Headings struct {
+	// Anchors adds a self-link to every h2-h4 so readers can copy a link to a section.
+	Anchors bool `toml:"anchors"`
 }
 
 type SiteConfig struct {
+	// Version is the config format version, see CurrentVersion.
+	Version     int    `toml:"version"`
 	BaseHTML    string `toml:"base_html"`
 	OutputDir   string `toml:"output_dir"`
 	Dirs        Dirs   `toml:"dirs"`
 	DraftPrefix string `toml:"draft_prefix"`
+	Images      Images `toml:"images"`
+	// Archives generates archive/, archive/<year>/ and archive/<year>/<month>/
+	// pages listing posts by their date.
+	Archives bool `toml:"archives"`
+	// Series generates series/ and series/<name>/ pages listing posts that share a
+	// velcro:series meta tag.
+	Series bool `toml:"series"`
+	// SearchIndex writes search-index.json with the text of every post for
+	// client-side search.
+	SearchIndex bool `toml:"search_index"`
+	// PostsJSON writes posts.json with the metadata, word count, reading time and
+	// excerpt of every post.
+	PostsJSON bool `toml:"posts_json"`
+	// SocialTags adds Open Graph and Twitter card tags and a canonical link to every
+	// page that does not have its own. URLs and images are only added with base_url.
+	SocialTags bool `toml:"social_tags"`
+	// StructuredData adds JSON-LD to posts (BlogPosting) and the home page (WebSite
+	// and Person, from the author in [site]).
+	StructuredData bool `toml:"structured_data"`
+	// RelatedPosts is the most posts <!-- include="@related" --> lists, picked by
+	// the keywords they share with the current post. 0 disables it.
+	RelatedPosts int `toml:"related_posts"`
+	// Pagination splits pages that list posts with <!-- include="@postlist" -->.
+	Pagination Pagination `toml:"pagination"`
+	// Headings configures the ids given to h2-h4 elements that have none.
+	Headings Headings `toml:"headings"`
+	// Layouts maps layout names to HTML files. The "posts" and "pages" keys and keys
+	// matching a page/post folder name are picked automatically.
+	Layouts map[string]string `toml:"layouts"`
+	// FollowSymlinks allows symlinks that point outside of the project root.
+	FollowSymlinks bool `toml:"follow_symlinks"`
+	// ActiveClass is the class added to elements whose data-page matches the
+	// current page.
+	ActiveClass string `toml:"active_class"`
+	// Site holds site-wide values such as the site name or author, available to
+	// pages as <!-- value="site.<key>" -->.
+	Site map[string]any `toml:"site"`
+	// BaseURL is the public URL the site is served from, such as https://example.com.
+	BaseURL string `toml:"base_url"`
+	// Env holds [env.<name>] tables that override the keys above for one environment.
+	// They are applied while loading, see Overrides.
+	Env map[string]toml.Primitive `toml:"env,omitempty"`
 }
 
-func LoadSiteConfig(path string) (*SiteConfig, error) {
+// FileName is the name of the site config at the root of a project.
+const FileName = "site.config.toml"
+
+// FindSiteConfig looks for the site config in dir and then in each of its parents,
+// so commands work from anywhere inside a project.
+func FindSiteConfig(dir string) (string, error) {
+	absoluteDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for current := absoluteDir; ; {
+		configPath := filepath.Join(current, FileName)
+		if _, err := os.Stat(configPath); err == nil {
+			return configPath, nil
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return "", fmt.Errorf("no %s found in %s or any of its parents", FileName, absoluteDir)
+		}
+		current = parent
+	}
+}
+
+// ResolvePath resolves a path from the site config against the directory the
+// config is in. Absolute paths are used as they are.
+func ResolvePath(configDir, p string) string {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(configDir, p)
+}
+
+// LoadSiteConfig reads the config at path, applies overrides and defaults and
+// validates the result.
+func LoadSiteConfig(path string, overrides Overrides) (*SiteConfig, error) {
 	var config SiteConfig
 
 	slog.Info("Loading site config from", "path", path)
-	_, err := toml.DecodeFile(path, &config)
+	md, err := toml.DecodeFile(path, &config)
 	if err != nil {
 		return nil, err
 	}
 
+	src, err := config.applyOverrides(md, overrides)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	config.applyDefaults(src)
+
+	problems := config.validate(filepath.Dir(path), src)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Path: path, Problems: problems}
+	}
+
+	if config.Version < CurrentVersion {
+		slog.Warn("Your site config is out of date, run velcro upgrade to migrate it", "version", config.Version, "current", CurrentVersion)
+	}
+
 	return &config, nil
 }
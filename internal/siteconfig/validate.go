@@ -0,0 +1,273 @@
+package siteconfig
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Defaults applied to keys left empty in site.config.toml. Directories default to
+// folders inside dirs.root.
+const (
+	DefaultBaseHTML     = "./src/base.html"
+	DefaultOutputDir    = "dist"
+	DefaultRoot         = "./src"
+	DefaultDraftPrefix  = "_"
+	DefaultScaffolds    = "./scaffolds"
+	DefaultQuality      = 82
+	DefaultEager        = 1
+	DefaultActiveClass  = "active"
+	DefaultPageSize     = 10
+	DefaultRelatedPosts = 3
+)
+
+// ValidationError lists every problem found in a site config.
+type ValidationError struct {
+	Path     string
+	Problems []error
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		problems[i] = problem.Error()
+	}
+	return fmt.Sprintf("invalid site config %s: %s", e.Path, strings.Join(problems, "; "))
+}
+
+// applyDefaults fills in every key that was left empty or, where an empty value
+// is meaningful, not set at all.
+func (c *SiteConfig) applyDefaults(src sources) {
+	if c.BaseHTML == "" {
+		c.BaseHTML = DefaultBaseHTML
+	}
+	if c.OutputDir == "" {
+		c.OutputDir = DefaultOutputDir
+	}
+	if c.ActiveClass == "" {
+		c.ActiveClass = DefaultActiveClass
+	}
+	if !src.isDefined("draft_prefix") {
+		c.DraftPrefix = DefaultDraftPrefix
+	}
+
+	if c.Dirs.Root == "" {
+		c.Dirs.Root = DefaultRoot
+	}
+	for _, dir := range []struct {
+		value *string
+		name  string
+	}{
+		{&c.Dirs.Pages, "pages"},
+		{&c.Dirs.Posts, "posts"},
+		{&c.Dirs.Assets, "assets"},
+		{&c.Dirs.Styles, "styles"},
+		{&c.Dirs.Scripts, "scripts"},
+		{&c.Dirs.Components, "components"},
+		{&c.Dirs.Data, "data"},
+	} {
+		if *dir.value == "" {
+			*dir.value = ResolvePath(c.Dirs.Root, dir.name)
+		}
+	}
+	if c.Dirs.Scaffolds == "" {
+		c.Dirs.Scaffolds = DefaultScaffolds
+	}
+
+	if c.Images.Quality == 0 {
+		c.Images.Quality = DefaultQuality
+	}
+	if !src.isDefined("related_posts") {
+		c.RelatedPosts = DefaultRelatedPosts
+	}
+	if c.Pagination.PageSize == 0 {
+		c.Pagination.PageSize = DefaultPageSize
+	}
+	if !src.isDefined("images", "eager") {
+		c.Images.Eager = DefaultEager
+	}
+}
+
+// validate checks the config against the project in rootDir, returning every
+// problem found. Missing optional directories are only logged.
+func (c *SiteConfig) validate(rootDir string, src sources) []error {
+	var problems []error
+
+	// Keys that do not map onto SiteConfig are almost always typos, unless the
+	// config predates them being renamed or moved
+	if c.Version < CurrentVersion {
+		for _, problem := range unknownKeys(src) {
+			slog.Warn("Ignoring key in outdated site config, run velcro upgrade to migrate it", "problem", problem)
+		}
+	} else {
+		problems = append(problems, unknownKeys(src)...)
+	}
+
+	absoluteOutputDir, _ := filepath.Abs(ResolvePath(rootDir, c.OutputDir))
+	absoluteRootDir, _ := filepath.Abs(rootDir)
+	if absoluteOutputDir == absoluteRootDir {
+		problems = append(problems, fmt.Errorf("output_dir %q is the project root, the build would write over your sources", c.OutputDir))
+	}
+
+	if err := checkPath(rootDir, c.BaseHTML, false); err != nil {
+		problems = append(problems, fmt.Errorf("base_html: %w", err))
+	}
+
+	for name, layoutPath := range c.Layouts {
+		if err := checkPath(rootDir, layoutPath, false); err != nil {
+			problems = append(problems, fmt.Errorf("layouts.%s: %w", name, err))
+		}
+	}
+
+	// Pages and posts are required, everything else is skipped when missing
+	for key, dir := range map[string]string{"dirs.pages": c.Dirs.Pages, "dirs.posts": c.Dirs.Posts} {
+		if err := checkPath(rootDir, dir, true); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	for key, dir := range map[string]string{
+		"assets":     c.Dirs.Assets,
+		"styles":     c.Dirs.Styles,
+		"scripts":    c.Dirs.Scripts,
+		"components": c.Dirs.Components,
+		"data":       c.Dirs.Data,
+	} {
+		if err := checkPath(rootDir, dir, true); err != nil && src.isDefined("dirs", key) {
+			slog.Warn("Configured directory is not available and will be skipped", "key", "dirs."+key, "error", err)
+		}
+	}
+
+	if c.BaseURL != "" {
+		baseURL, err := url.Parse(c.BaseURL)
+		if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+			problems = append(problems, fmt.Errorf("base_url must be an absolute URL such as https://example.com, got %q", c.BaseURL))
+		}
+	}
+
+	if c.Images.Quality < 1 || c.Images.Quality > 100 {
+		problems = append(problems, fmt.Errorf("images.quality must be between 1 and 100, got %d", c.Images.Quality))
+	}
+	for _, width := range c.Images.Widths {
+		if width <= 0 {
+			problems = append(problems, fmt.Errorf("images.widths must be positive, got %d", width))
+		}
+	}
+	if c.RelatedPosts < 0 {
+		problems = append(problems, fmt.Errorf("related_posts must not be negative, got %d", c.RelatedPosts))
+	}
+	if c.Pagination.PageSize < 0 {
+		problems = append(problems, fmt.Errorf("pagination.page_size must be positive, got %d", c.Pagination.PageSize))
+	}
+	if c.Images.Eager < 0 {
+		problems = append(problems, fmt.Errorf("images.eager must not be negative, got %d", c.Images.Eager))
+	}
+
+	return problems
+}
+
+// checkPath checks that a path from the config exists and is a directory or a file.
+func checkPath(rootDir, p string, wantDir bool) error {
+	info, err := os.Stat(ResolvePath(rootDir, p))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%q does not exist", p)
+	}
+	if err != nil {
+		return err
+	}
+
+	if wantDir && !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", p)
+	}
+	if !wantDir && info.IsDir() {
+		return fmt.Errorf("%q is a directory, expected a file", p)
+	}
+	return nil
+}
+
+// unknownKeys reports every key that does not map onto SiteConfig, suggesting the
+// closest known key.
+func unknownKeys(src sources) []error {
+	var problems []error
+	known := knownKeys(reflect.TypeFor[SiteConfig](), "")
+	for _, key := range src.undecoded() {
+		// [site] is free-form, but nested tables in it are still reported as undecoded
+		if key[0] == "site" || (len(key) > 2 && key[0] == "env" && key[2] == "site") {
+			continue
+		}
+
+		problem := fmt.Sprintf("unknown key %q", key.String())
+
+		// Keys in an environment table are suggested relative to that table
+		prefix, suggestKeyPart := "", key
+		if len(key) > 2 && key[0] == "env" {
+			prefix, suggestKeyPart = "env."+key[1]+".", key[2:]
+		}
+		if suggestion := suggestKey(suggestKeyPart.String(), known); suggestion != "" {
+			problem += fmt.Sprintf(", did you mean %q?", prefix+suggestion)
+		}
+		problems = append(problems, errors.New(problem))
+	}
+	return problems
+}
+
+// knownKeys lists the dotted TOML keys of a config struct.
+func knownKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		key := prefix + name
+		keys = append(keys, key)
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, knownKeys(field.Type, key+".")...)
+		}
+	}
+	return keys
+}
+
+// suggestKey returns the known key closest to an unknown one, matching either the
+// whole key or just its last part so keys in the wrong table are found too.
+func suggestKey(key string, known []string) string {
+	lastPart := key[strings.LastIndex(key, ".")+1:]
+
+	best := ""
+	bestDistance := 3
+	for _, candidate := range known {
+		candidateLastPart := candidate[strings.LastIndex(candidate, ".")+1:]
+		distance := min(levenshtein(key, candidate), levenshtein(lastPart, candidateLastPart)+1)
+		if distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,123 @@
+package upgrade
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// UnifiedDiff returns a unified diff between two files, or an empty string if they
+// are the same. Template files are small, so a plain LCS table is good enough.
+func UnifiedDiff(a, b []byte, fromName, toName string) string {
+	aLines := splitLines(string(a))
+	bLines := splitLines(string(b))
+
+	// lcs[i][j] is the length of the longest common subsequence of aLines[i:] and bLines[j:]
+	lcs := make([][]int, len(aLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bLines)+1)
+	}
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			if aLines[i] == bLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		switch {
+		case i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j]:
+			ops = append(ops, diffOp{' ', aLines[i]})
+			i++
+			j++
+		case i < len(aLines) && (j == len(bLines) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', aLines[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', bLines[j]})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	changed := false
+
+	// Group the operations into hunks with diffContext lines of context
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		changed = true
+
+		// Extend the hunk while the next change is close enough to share context
+		lastChange := start
+		for k := start + 1; k < len(ops) && k-lastChange <= 2*diffContext; k++ {
+			if ops[k].kind != ' ' {
+				lastChange = k
+			}
+		}
+
+		hunkStart := max(start-diffContext, 0)
+		hunkEnd := min(lastChange+1+diffContext, len(ops))
+
+		aStart, bStart := lineNumbers(ops[:hunkStart])
+		aCount, bCount := lineNumbers(ops[hunkStart:hunkEnd])
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", hunkLineStart(aStart, aCount), aCount, hunkLineStart(bStart, bCount), bCount)
+		for _, op := range ops[hunkStart:hunkEnd] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+
+		start = hunkEnd
+	}
+
+	if !changed {
+		return ""
+	}
+	return out.String()
+}
+
+// lineNumbers counts the lines of the old and new file covered by ops.
+func lineNumbers(ops []diffOp) (int, int) {
+	a, b := 0, 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			a++
+		}
+		if op.kind != '-' {
+			b++
+		}
+	}
+	return a, b
+}
+
+// hunkLineStart converts a count of preceding lines into the 1-based start line of
+// a hunk header. Empty ranges point at the line before, as in diff -u.
+func hunkLineStart(preceding, count int) int {
+	if count == 0 {
+		return preceding
+	}
+	return preceding + 1
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,81 @@
+package upgrade
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// SnapshotDir holds a copy of the template files exactly as velcro init wrote them.
+// It is the common ancestor used to tell which files the user has changed.
+const SnapshotDir = ".velcro/template"
+
+// ManifestPath records which template a site was created from and with which values.
+const ManifestPath = ".velcro/template.toml"
+
+type Manifest struct {
+	Template string            `toml:"template"`
+	Values   map[string]string `toml:"values"`
+}
+
+// WriteSnapshot stores the rendered template files and the manifest in rootDir.
+func WriteSnapshot(rootDir string, manifest Manifest, files map[string][]byte) error {
+	snapshotDir := filepath.Join(rootDir, SnapshotDir)
+	err := os.RemoveAll(snapshotDir)
+	if err != nil {
+		return err
+	}
+
+	for path, content := range files {
+		dstPath := filepath.Join(snapshotDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dstPath, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(filepath.Join(rootDir, filepath.Dir(ManifestPath)), 0755)
+	if err != nil {
+		return err
+	}
+
+	manifestFile, err := os.Create(filepath.Join(rootDir, ManifestPath))
+	if err != nil {
+		return err
+	}
+	defer manifestFile.Close()
+
+	return toml.NewEncoder(manifestFile).Encode(manifest)
+}
+
+// ReadSnapshot loads the manifest and template snapshot written by WriteSnapshot.
+func ReadSnapshot(rootDir string) (*Manifest, map[string][]byte, error) {
+	var manifest Manifest
+	_, err := toml.DecodeFile(filepath.Join(rootDir, ManifestPath), &manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := make(map[string][]byte)
+	snapshotFS := os.DirFS(filepath.Join(rootDir, SnapshotDir))
+	err = fs.WalkDir(snapshotFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(snapshotFS, path)
+		if err != nil {
+			return err
+		}
+		files[path] = content
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &manifest, files, nil
+}
@@ -0,0 +1,130 @@
+package upgrade
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+type Status int
+
+const (
+	// Update means the user has not touched the file and the template changed it.
+	Update Status = iota
+	// Add means the template has a new file the site does not have yet.
+	Add
+	// Conflict means both the user and the template changed the file.
+	Conflict
+)
+
+func (s Status) String() string {
+	switch s {
+	case Update:
+		return "update"
+	case Add:
+		return "add"
+	default:
+		return "conflict"
+	}
+}
+
+type FileChange struct {
+	Path   string
+	Status Status
+	// Diff shows the change to the user's file for updates and additions, and the
+	// template's own change since the snapshot for conflicts.
+	Diff string
+	// Content is the new template version of the file.
+	Content []byte
+}
+
+// Plan works out how to bring the files in rootDir up to date with a newer
+// version of their template. base is the snapshot of the template the site was
+// created from and theirs is the new template, both rendered with the same values.
+// Paths listed in skip (such as the site config, which is migrated separately)
+// are left alone.
+func Plan(rootDir string, base, theirs map[string][]byte, skip ...string) ([]FileChange, error) {
+	var changes []FileChange
+
+	for _, path := range slices.Sorted(maps.Keys(theirs)) {
+		if slices.Contains(skip, path) {
+			continue
+		}
+
+		theirContent := theirs[path]
+		baseContent, inBase := base[path]
+
+		ourContent, err := os.ReadFile(filepath.Join(rootDir, filepath.FromSlash(path)))
+		if errors.Is(err, fs.ErrNotExist) {
+			// A file the user deleted stays deleted
+			if inBase {
+				continue
+			}
+			changes = append(changes, FileChange{
+				Path:    path,
+				Status:  Add,
+				Diff:    UnifiedDiff(nil, theirContent, "/dev/null", path),
+				Content: theirContent,
+			})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Equal(ourContent, theirContent) {
+			continue
+		}
+
+		switch {
+		case inBase && bytes.Equal(ourContent, baseContent):
+			changes = append(changes, FileChange{
+				Path:    path,
+				Status:  Update,
+				Diff:    UnifiedDiff(ourContent, theirContent, path, path),
+				Content: theirContent,
+			})
+		case inBase && bytes.Equal(theirContent, baseContent):
+			// Only the user changed this file, keep their version
+		case inBase:
+			changes = append(changes, FileChange{
+				Path:    path,
+				Status:  Conflict,
+				Diff:    UnifiedDiff(baseContent, theirContent, "template (old)/"+path, "template (new)/"+path),
+				Content: theirContent,
+			})
+		default:
+			changes = append(changes, FileChange{
+				Path:    path,
+				Status:  Conflict,
+				Diff:    UnifiedDiff(ourContent, theirContent, path, "template/"+path),
+				Content: theirContent,
+			})
+		}
+	}
+
+	return changes, nil
+}
+
+// Apply writes the new content of every update and addition. Conflicts are left
+// for the user to merge by hand.
+func Apply(rootDir string, changes []FileChange) error {
+	for _, change := range changes {
+		if change.Status == Conflict {
+			continue
+		}
+
+		dstPath := filepath.Join(rootDir, filepath.FromSlash(change.Path))
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dstPath, change.Content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}